@@ -3,6 +3,8 @@ package env
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 )
 
 // Required returns the value of the named environment variable.
@@ -14,3 +16,62 @@ func Required(key string) string {
 	}
 	return v
 }
+
+// Bool reports whether the named environment variable is set to a true value,
+// as parsed by strconv.ParseBool. An unset or empty variable is false.
+// It calls log.Fatalf if the value cannot be parsed.
+func Bool(key string) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return b
+}
+
+// Duration returns the named environment variable parsed as a time.Duration,
+// or fallback if it is unset or empty. It calls log.Fatalf if the value cannot
+// be parsed or is not positive.
+func Duration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s: must be a positive duration such as 30s, got %q", key, v)
+	}
+	return d
+}
+
+// NonNegativeDuration is like Duration but also accepts zero, for delays that
+// can be turned off.
+func NonNegativeDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("Invalid %s: must be zero or a positive duration such as 30s, got %q", key, v)
+	}
+	return d
+}
+
+// Int returns the named environment variable parsed as an integer, or fallback
+// if it is unset or empty. It calls log.Fatalf if the value cannot be parsed or
+// is not positive.
+func Int(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		log.Fatalf("Invalid %s: must be a positive integer, got %q", key, v)
+	}
+	return n
+}
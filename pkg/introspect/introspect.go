@@ -0,0 +1,156 @@
+// Package introspect protects HTTP handlers by validating each request's
+// session cookie or Bearer token against auth-api's POST /api/introspect.
+package introspect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionCookie is the name of auth-api's session cookie.
+const SessionCookie = "auth_session"
+
+// maxCacheEntries bounds the result cache; past it, expired entries are swept
+// and, if that is not enough, the cache is cleared.
+const maxCacheEntries = 1024
+
+type cacheEntry struct {
+	valid   bool
+	expires time.Time
+}
+
+// Client checks credentials against an introspection endpoint, caching each
+// result for a short time so a busy client does not cost a round trip per
+// request. It is safe for concurrent use.
+type Client struct {
+	url  string
+	ttl  time.Duration
+	http *http.Client
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]cacheEntry
+}
+
+// New returns a Client for the introspection endpoint at url, such as
+// http://auth-api:8081/api/introspect, that caches results for ttl. Revoked
+// sessions and tokens are therefore accepted for up to ttl.
+func New(url string, ttl time.Duration) *Client {
+	return &Client{
+		url:   url,
+		ttl:   ttl,
+		http:  &http.Client{Timeout: 5 * time.Second},
+		cache: make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+// Check reports whether r carries a valid session cookie or Bearer token. It
+// returns an error only if the endpoint could not give an answer.
+func (c *Client) Check(ctx context.Context, r *http.Request) (bool, error) {
+	valid, _, err := c.check(ctx, r)
+	return valid, err
+}
+
+// check is Check that also returns the Set-Cookie headers of the endpoint's
+// answer, which reissue a session cookie nearing expiry. Cached answers have
+// none.
+func (c *Client) check(ctx context.Context, r *http.Request) (bool, []string, error) {
+	cookie, _ := r.Cookie(SessionCookie)
+	auth := r.Header.Get("Authorization")
+	if cookie == nil && !strings.HasPrefix(auth, "Bearer ") {
+		return false, nil, nil
+	}
+
+	var cookieValue string
+	if cookie != nil {
+		cookieValue = cookie.Value
+	}
+	key := sha256.Sum256([]byte(cookieValue + "\x00" + auth))
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.valid, nil, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, nil)
+	if err != nil {
+		return false, nil, err
+	}
+	if cookie != nil {
+		req.AddCookie(&http.Cookie{Name: SessionCookie, Value: cookieValue})
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, nil, err
+	}
+	resp.Body.Close()
+
+	var valid bool
+	switch resp.StatusCode {
+	case http.StatusOK:
+		valid = true
+	case http.StatusUnauthorized:
+	default:
+		return false, nil, fmt.Errorf("introspect: unexpected status %d", resp.StatusCode)
+	}
+
+	c.mu.Lock()
+	if len(c.cache) >= maxCacheEntries {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		if len(c.cache) >= maxCacheEntries {
+			clear(c.cache)
+		}
+	}
+	c.cache[key] = cacheEntry{valid: valid, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return valid, resp.Header.Values("Set-Cookie"), nil
+}
+
+// Middleware answers 401 to requests without a valid session cookie or Bearer
+// token, and 503 if the endpoint cannot be reached, before calling next.
+// Error bodies are problem details like auth-api's own. A session cookie the
+// endpoint reissues is passed on to the client, keeping the session alive.
+func (c *Client) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		valid, cookies, err := c.check(r.Context(), r)
+		switch {
+		case err != nil:
+			writeProblem(w, http.StatusServiceUnavailable, "auth_unavailable", "could not reach the authentication service")
+		case !valid:
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeProblem(w, http.StatusUnauthorized, "unauthorized", "no valid session or token")
+		default:
+			for _, v := range cookies {
+				w.Header().Add("Set-Cookie", v)
+			}
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Type   string `json:"type"`
+		Title  string `json:"title"`
+		Status int    `json:"status"`
+		Detail string `json:"detail"`
+		Code   string `json:"code"`
+	}{"about:blank", http.StatusText(status), status, detail, code})
+}
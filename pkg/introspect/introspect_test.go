@@ -0,0 +1,246 @@
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// authServer is a fake auth-api introspection endpoint that accepts the
+// session cookie "good" and the token "Bearer good".
+type authServer struct {
+	*httptest.Server
+	calls      atomic.Int32
+	status     atomic.Int32 // forced status, if set
+	lastCookie atomic.Value
+	lastAuth   atomic.Value
+	setCookie  string // sent with valid cookie sessions, if set
+}
+
+func newAuthServer(t *testing.T) *authServer {
+	t.Helper()
+	s := &authServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var cookie string
+		if c, err := r.Cookie(SessionCookie); err == nil {
+			cookie = c.Value
+		}
+		s.lastCookie.Store(cookie)
+		s.lastAuth.Store(r.Header.Get("Authorization"))
+
+		if status := s.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		switch {
+		case cookie == "good":
+			if s.setCookie != "" {
+				w.Header().Add("Set-Cookie", s.setCookie)
+			}
+		case r.Header.Get("Authorization") == "Bearer good":
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func request(cookie, auth string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/today", nil)
+	if cookie != "" {
+		r.AddCookie(&http.Cookie{Name: SessionCookie, Value: cookie})
+	}
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+	return r
+}
+
+func TestCheckForwardsCredentials(t *testing.T) {
+	srv := newAuthServer(t)
+	c := New(srv.URL, time.Minute)
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		cookie, auth string
+		want         bool
+	}{
+		{cookie: "good", want: true},
+		{auth: "Bearer good", want: true},
+		{cookie: "bad", want: false},
+		{auth: "Bearer bad", want: false},
+		{cookie: "bad", auth: "Bearer good", want: true},
+	} {
+		valid, err := c.Check(ctx, request(tc.cookie, tc.auth))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if valid != tc.want {
+			t.Errorf("cookie %q auth %q: valid = %v, want %v", tc.cookie, tc.auth, valid, tc.want)
+		}
+		if got := srv.lastCookie.Load(); got != tc.cookie {
+			t.Errorf("cookie %q auth %q: endpoint got cookie %q", tc.cookie, tc.auth, got)
+		}
+		if got := srv.lastAuth.Load(); got != tc.auth {
+			t.Errorf("cookie %q auth %q: endpoint got Authorization %q", tc.cookie, tc.auth, got)
+		}
+	}
+}
+
+func TestCheckWithoutCredentials(t *testing.T) {
+	srv := newAuthServer(t)
+	c := New(srv.URL, time.Minute)
+
+	for _, r := range []*http.Request{request("", ""), request("", "Basic dXNlcjpwYXNz")} {
+		valid, err := c.Check(context.Background(), r)
+		if err != nil || valid {
+			t.Errorf("valid = %v, err = %v; want false, nil", valid, err)
+		}
+	}
+	if n := srv.calls.Load(); n != 0 {
+		t.Errorf("endpoint called %d times for requests without credentials", n)
+	}
+}
+
+func TestCheckCache(t *testing.T) {
+	srv := newAuthServer(t)
+	c := New(srv.URL, 50*time.Millisecond)
+	ctx := context.Background()
+
+	for range 3 {
+		if valid, err := c.Check(ctx, request("good", "")); err != nil || !valid {
+			t.Fatalf("valid = %v, err = %v", valid, err)
+		}
+		if valid, err := c.Check(ctx, request("bad", "")); err != nil || valid {
+			t.Fatalf("valid = %v, err = %v", valid, err)
+		}
+	}
+	if n := srv.calls.Load(); n != 2 {
+		t.Errorf("endpoint called %d times, want 2: one per credential", n)
+	}
+
+	// Once the TTL passes, the endpoint is asked again; a revocation is
+	// picked up.
+	time.Sleep(60 * time.Millisecond)
+	srv.status.Store(http.StatusUnauthorized)
+	if valid, err := c.Check(ctx, request("good", "")); err != nil || valid {
+		t.Errorf("after expiry: valid = %v, err = %v; want false, nil", valid, err)
+	}
+	if n := srv.calls.Load(); n != 3 {
+		t.Errorf("endpoint called %d times, want 3", n)
+	}
+}
+
+func TestCheckUnavailable(t *testing.T) {
+	srv := newAuthServer(t)
+	srv.status.Store(http.StatusInternalServerError)
+	c := New(srv.URL, time.Minute)
+
+	if _, err := c.Check(context.Background(), request("good", "")); err == nil {
+		t.Error("500 from the endpoint: want an error")
+	}
+	// Failures are not cached.
+	srv.status.Store(0)
+	if valid, err := c.Check(context.Background(), request("good", "")); err != nil || !valid {
+		t.Errorf("after recovery: valid = %v, err = %v", valid, err)
+	}
+}
+
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+}
+
+func serve(t *testing.T, c *Client, r *http.Request) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	var called bool
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.Write([]byte("ok"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec, called
+}
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem {
+	t.Helper()
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+	var p problem
+	if err := json.NewDecoder(rec.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestMiddlewareUnauthorized(t *testing.T) {
+	srv := newAuthServer(t)
+	c := New(srv.URL, time.Minute)
+
+	rec, called := serve(t, c, request("bad", ""))
+	if called {
+		t.Error("handler called for an invalid session")
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", rec.Code)
+	}
+	if got := rec.Header().Get("WWW-Authenticate"); got != "Bearer" {
+		t.Errorf("WWW-Authenticate = %q, want Bearer", got)
+	}
+	want := problem{Type: "about:blank", Title: "Unauthorized", Status: 401, Detail: "no valid session or token", Code: "unauthorized"}
+	if p := decodeProblem(t, rec); p != want {
+		t.Errorf("body = %+v, want %+v", p, want)
+	}
+}
+
+func TestMiddlewareUnavailable(t *testing.T) {
+	srv := newAuthServer(t)
+	c := New(srv.URL, time.Minute)
+	srv.Close()
+
+	rec, called := serve(t, c, request("good", ""))
+	if called {
+		t.Error("handler called while auth-api is down")
+	}
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want 503", rec.Code)
+	}
+	if p := decodeProblem(t, rec); p.Code != "auth_unavailable" || p.Status != 503 {
+		t.Errorf("body = %+v, want code auth_unavailable", p)
+	}
+}
+
+func TestMiddlewarePassesReissuedCookie(t *testing.T) {
+	srv := newAuthServer(t)
+	srv.setCookie = SessionCookie + "=renewed; Path=/; HttpOnly"
+	c := New(srv.URL, time.Minute)
+
+	rec, called := serve(t, c, request("good", ""))
+	if !called || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, handler called = %v", rec.Code, called)
+	}
+	if got := rec.Header().Get("Set-Cookie"); got != srv.setCookie {
+		t.Errorf("Set-Cookie = %q, want %q", got, srv.setCookie)
+	}
+
+	// A cached answer has nothing to pass on.
+	rec, _ = serve(t, c, request("good", ""))
+	if got := rec.Header().Get("Set-Cookie"); got != "" {
+		t.Errorf("cached: Set-Cookie = %q, want none", got)
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.local/services/auth-api/internal/db"
+	"go.local/services/auth-api/internal/store"
+)
+
+// memSessions is an in-memory SessionStore.
+type memSessions struct {
+	sessions map[string]store.AuthSession
+}
+
+func (s *memSessions) CreateAuthSession(ctx context.Context, id string, session *store.AuthSession) (string, error) {
+	s.sessions[id] = *session
+	return id, nil
+}
+
+func (s *memSessions) GetAuthSession(ctx context.Context, token string) (*store.AuthSession, error) {
+	session, ok := s.sessions[token]
+	if !ok {
+		return nil, errors.New("session not found")
+	}
+	return &session, nil
+}
+
+func (s *memSessions) DeleteAuthSession(ctx context.Context, token string) error {
+	delete(s.sessions, token)
+	return nil
+}
+
+func (s *memSessions) RevokeAccountSessions(ctx context.Context, userHandle []byte) error {
+	for token, session := range s.sessions {
+		if bytes.Equal(session.UserHandle, userHandle) {
+			delete(s.sessions, token)
+		}
+	}
+	return nil
+}
+
+// emptyDB is a database without rows: every query finds nothing.
+type emptyDB struct{}
+
+func (emptyDB) Exec(context.Context, string, ...interface{}) (pgconn.CommandTag, error) {
+	return pgconn.CommandTag{}, nil
+}
+
+func (emptyDB) Query(context.Context, string, ...interface{}) (pgx.Rows, error) {
+	return nil, pgx.ErrNoRows
+}
+
+func (emptyDB) QueryRow(context.Context, string, ...interface{}) pgx.Row {
+	return noRow{}
+}
+
+type noRow struct{}
+
+func (noRow) Scan(...any) error { return pgx.ErrNoRows }
+
+// newTestHandler returns a Handler with in-memory sessions and an empty
+// database. WebAuthn and Store are nil, so the ceremony endpoints can only be
+// exercised up to their first use.
+func newTestHandler() (*Handler, *memSessions) {
+	sessions := &memSessions{sessions: make(map[string]store.AuthSession)}
+	return &Handler{Queries: db.New(emptyDB{}), Sessions: sessions}, sessions
+}
+
+// serve runs a request with the given body through the handler, with an
+// auth_session cookie if token is not empty.
+func serve(h http.HandlerFunc, method, target, body, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+	if token != "" {
+		r.AddCookie(&http.Cookie{Name: "auth_session", Value: token})
+	}
+	rec := httptest.NewRecorder()
+	h(rec, r)
+	return rec
+}
+
+// checkProblem fails the test unless rec is a problem response with the given
+// status and code.
+func checkProblem(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Errorf("status = %d, want %d", rec.Code, status)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("Content-Type = %q, want application/problem+json", ct)
+	}
+	var p Problem
+	if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+		t.Fatalf("body %q: %v", rec.Body, err)
+	}
+	if p.Code != code || p.Status != status || p.Title != http.StatusText(status) {
+		t.Errorf("problem = %+v, want code %s and status %d", p, code, status)
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+// readyTimeout bounds each dependency check in Readiness.
+const readyTimeout = 2 * time.Second
+
+// Health serves liveness and readiness probes for Docker and Kubernetes.
+type Health struct {
+	Pool  *pgxpool.Pool
+	Redis *redis.Client
+
+	draining atomic.Bool
+}
+
+// SetDraining marks the server as shutting down. Readiness fails from then on
+// so load balancers stop routing new requests while in-flight ones complete.
+func (h *Health) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Liveness reports that the process is up and serving HTTP. It does not check
+// dependencies, so a Postgres or Redis outage does not trigger a restart.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// Readiness reports whether the server can handle traffic: it is not draining
+// and both Postgres and Redis respond to a ping.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		writeReadiness(w, false, map[string]string{"server": "draining"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	checks := map[string]string{"postgres": "ok", "redis": "ok"}
+	ready := true
+	if err := h.Pool.Ping(ctx); err != nil {
+		checks["postgres"] = "unavailable"
+		ready = false
+	}
+	if err := h.Redis.Ping(ctx).Err(); err != nil {
+		checks["redis"] = "unavailable"
+		ready = false
+	}
+
+	writeReadiness(w, ready, checks)
+}
+
+func writeReadiness(w http.ResponseWriter, ready bool, checks map[string]string) {
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"ready": ready, "checks": checks})
+}
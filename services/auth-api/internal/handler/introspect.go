@@ -3,13 +3,24 @@ package handler
 import (
 	"net/http"
 	"strings"
+	"time"
+
+	"go.local/services/auth-api/internal/store"
 )
 
 // Introspect validates either a session cookie or a Bearer token.
-// Used by Caddy's forward_auth directive.
+// Used by Caddy's forward_auth directive and pkg/introspect.
+//
+// A cookie session past half its sliding TTL is reissued, so traffic that only
+// reaches auth-api through introspection keeps it alive like Redis sessions
+// are kept alive on read. Waiting until half-time lets callers that cache
+// results by cookie keep hitting their cache.
 func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
 	if cookie, err := r.Cookie("auth_session"); err == nil {
-		if _, err := h.Store.GetAuthSession(r.Context(), cookie.Value); err == nil {
+		if session, err := h.Sessions.GetAuthSession(r.Context(), cookie.Value); err == nil {
+			if time.Until(session.ExpiresAt) < store.AuthSessionTTL/2 {
+				h.refreshAuthSession(w, cookie.Value, session)
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -22,7 +33,7 @@ func (h *Handler) Introspect(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	w.WriteHeader(http.StatusUnauthorized)
+	writeProblem(w, http.StatusUnauthorized, CodeUnauthorized, "no valid session or token")
 }
 
 func parseBearerToken(r *http.Request) string {
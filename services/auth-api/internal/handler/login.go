@@ -7,24 +7,25 @@ import (
 	"github.com/go-webauthn/webauthn/webauthn"
 	"go.local/services/auth-api/internal/db"
 	"go.local/services/auth-api/internal/model"
+	"go.local/services/auth-api/internal/store"
 )
 
 // BeginLogin starts a discoverable login ceremony (no username required).
 func (h *Handler) BeginLogin(w http.ResponseWriter, r *http.Request) {
 	assertion, session, err := h.WebAuthn.BeginDiscoverableLogin()
 	if err != nil {
-		http.Error(w, "failed to begin login", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	sessionID, err := generateSessionID()
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	if err := h.Store.SaveWebAuthnSession(r.Context(), sessionID, session); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
@@ -46,13 +47,17 @@ func (h *Handler) BeginLogin(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) FinishLogin(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("webauthn_session")
 	if err != nil {
-		http.Error(w, "missing session cookie", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeMissingCeremonySession, "missing session cookie")
+		return
+	}
+
+	if !readCeremonyBody(w, r) {
 		return
 	}
 
 	session, err := h.Store.GetWebAuthnSession(r.Context(), cookie.Value)
 	if err != nil {
-		http.Error(w, "session expired or invalid", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeCeremonySessionInvalid, "session expired or invalid")
 		return
 	}
 
@@ -71,7 +76,7 @@ func (h *Handler) FinishLogin(w http.ResponseWriter, r *http.Request) {
 
 	credential, err := h.WebAuthn.FinishDiscoverableLogin(discoverableUserHandler, *session, r)
 	if err != nil {
-		http.Error(w, "login failed", http.StatusUnauthorized)
+		writeProblem(w, http.StatusUnauthorized, CodeLoginFailed, "login failed")
 		return
 	}
 
@@ -80,12 +85,17 @@ func (h *Handler) FinishLogin(w http.ResponseWriter, r *http.Request) {
 		SignCount:       int64(credential.Authenticator.SignCount),
 		FlagBackupState: credential.Flags.BackupState,
 	}); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
-	if err := h.createAuthSession(w, r, authenticatedCred.DisplayName.String); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if err := h.createAuthSession(w, r, &store.AuthSession{
+		UserHandle:   authenticatedCred.UserHandle,
+		DisplayName:  authenticatedCred.DisplayName.String,
+		Method:       store.MethodPasskeyLogin,
+		CredentialID: credential.ID,
+	}); err != nil {
+		writeInternalError(w)
 		return
 	}
 
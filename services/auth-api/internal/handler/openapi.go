@@ -0,0 +1,11 @@
+package handler
+
+import "net/http"
+
+// OpenAPI serves the embedded OpenAPI document describing this API.
+func OpenAPI(spec []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(spec)
+	}
+}
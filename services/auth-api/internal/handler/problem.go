@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes carried in the "code" member of every problem response. They are
+// stable identifiers for clients to branch on; the detail text may change.
+const (
+	CodeInvalidRequestBody     = "invalid_request_body"
+	CodeRequestTooLarge        = "request_too_large"
+	CodeNameRequired           = "name_required"
+	CodeNameTooLong            = "name_too_long"
+	CodeMissingCeremonySession = "missing_ceremony_session"
+	CodeCeremonySessionInvalid = "ceremony_session_invalid"
+	CodeRegistrationFailed     = "registration_failed"
+	CodeLoginFailed            = "login_failed"
+	CodeUnauthorized           = "unauthorized"
+	CodeInvalidTokenID         = "invalid_token_id"
+	CodeTokenNotFound          = "token_not_found"
+	CodeSessionNotLinked       = "session_not_linked"
+	CodeInternalError          = "internal_error"
+)
+
+// Problem is an RFC 9457 problem details object. Type is always "about:blank",
+// so Title is the HTTP status text and Code identifies the specific failure.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// writeProblem writes a problem details response with the given status, error
+// code and human-readable detail.
+func writeProblem(w http.ResponseWriter, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	})
+}
+
+// writeInternalError writes a generic 500 problem. Internal details are never
+// exposed to the client.
+func writeInternalError(w http.ResponseWriter) {
+	writeProblem(w, http.StatusInternalServerError, CodeInternalError, "internal error")
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.local/services/auth-api/internal/store"
+)
+
+func TestProblemResponses(t *testing.T) {
+	h, sessions := newTestHandler()
+	sessions.sessions["alice"] = store.AuthSession{UserHandle: []byte("alice")}
+	sessions.sessions["unlinked"] = store.AuthSession{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/me", h.RequireSession(h.Me))
+	mux.HandleFunc("POST /api/logout/all", h.RequireSession(h.LogoutEverywhere))
+	mux.HandleFunc("POST /api/tokens", h.RequireSession(h.CreateToken))
+	mux.HandleFunc("PATCH /api/tokens/{id}", h.RequireSession(h.UpdateToken))
+
+	for _, tc := range []struct {
+		name               string
+		method, path, body string
+		token              string
+		status             int
+		code               string
+	}{
+		{"no session", "GET", "/api/me", "", "", http.StatusUnauthorized, CodeUnauthorized},
+		{"unknown session", "GET", "/api/me", "", "mallory", http.StatusUnauthorized, CodeUnauthorized},
+		{"unknown token", "PATCH", "/api/tokens/0b6f1e0c-6a53-4b8e-9c1d-2f3a4b5c6d7e", `{"name":"ci"}`, "alice", http.StatusNotFound, CodeTokenNotFound},
+		{"invalid token id", "PATCH", "/api/tokens/42", `{"name":"ci"}`, "alice", http.StatusBadRequest, CodeInvalidTokenID},
+		{"unlinked session", "POST", "/api/logout/all", "", "unlinked", http.StatusConflict, CodeSessionNotLinked},
+		{"oversized body", "POST", "/api/tokens", `{"name":"` + strings.Repeat("x", maxJSONBodyBytes) + `"}`, "alice", http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.token != "" {
+				r.AddCookie(&http.Cookie{Name: "auth_session", Value: tc.token})
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, r)
+			checkProblem(t, rec, tc.status, tc.code)
+		})
+	}
+}
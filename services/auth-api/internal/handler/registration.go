@@ -18,53 +18,47 @@ type Handler struct {
 	WebAuthn     *webauthn.WebAuthn
 	Queries      *db.Queries
 	Store        *store.RedisStore
+	Sessions     SessionStore
 	SecureCookie bool
 }
 
 // BeginPasskeyRegistration starts the WebAuthn registration ceremony directly.
 func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	name, ok := decodeName(w, r)
+	if !ok {
 		return
 	}
 
 	userHandle := make([]byte, 32)
 	if _, err := rand.Read(userHandle); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	cred := &model.Credential{DB: db.Credential{
 		UserHandle:  userHandle,
-		DisplayName: pgtype.Text{String: req.Name, Valid: true},
+		DisplayName: pgtype.Text{String: name, Valid: true},
 	}}
 
 	creation, session, err := h.WebAuthn.BeginRegistration(cred,
 		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
 	)
 	if err != nil {
-		http.Error(w, "failed to begin registration", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	sessionID, err := generateSessionID()
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	if err := h.Store.SaveRegistrationSession(r.Context(), sessionID, &store.RegistrationSession{
-		DisplayName: req.Name,
+		DisplayName: name,
 		WebAuthn:    session,
 	}); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
@@ -86,13 +80,17 @@ func (h *Handler) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Reques
 func (h *Handler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("webauthn_session")
 	if err != nil {
-		http.Error(w, "missing session cookie", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeMissingCeremonySession, "missing session cookie")
+		return
+	}
+
+	if !readCeremonyBody(w, r) {
 		return
 	}
 
 	regSession, err := h.Store.GetRegistrationSession(r.Context(), cookie.Value)
 	if err != nil {
-		http.Error(w, "session expired or invalid", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeCeremonySessionInvalid, "session expired or invalid")
 		return
 	}
 
@@ -106,7 +104,7 @@ func (h *Handler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
 
 	credential, err := h.WebAuthn.FinishRegistration(cred, *regSession.WebAuthn, r)
 	if err != nil {
-		http.Error(w, "registration failed", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeRegistrationFailed, "registration failed")
 		return
 	}
 
@@ -127,12 +125,17 @@ func (h *Handler) FinishRegistration(w http.ResponseWriter, r *http.Request) {
 		Aaguid:             credential.Authenticator.AAGUID,
 	})
 	if err != nil {
-		http.Error(w, "failed to save credential", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
-	if err := h.createAuthSession(w, r, regSession.DisplayName); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	if err := h.createAuthSession(w, r, &store.AuthSession{
+		UserHandle:   regSession.WebAuthn.UserID,
+		DisplayName:  regSession.DisplayName,
+		Method:       store.MethodPasskeyRegistration,
+		CredentialID: credential.ID,
+	}); err != nil {
+		writeInternalError(w)
 		return
 	}
 
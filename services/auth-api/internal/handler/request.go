@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxJSONBodyBytes caps small JSON request bodies such as {"name": "..."}.
+	maxJSONBodyBytes = 4 << 10
+
+	// maxCeremonyBodyBytes caps authenticator responses on the finish endpoints.
+	// Attestation objects with certificate chains are a few KB at most.
+	maxCeremonyBodyBytes = 64 << 10
+
+	// maxNameLength is the maximum length of a passkey or token name, in characters.
+	maxNameLength = 64
+)
+
+// nameRequest is the body accepted by registration begin and token create/update.
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+// decodeJSON decodes a single JSON object from the request body into dst,
+// rejecting bodies over maxJSONBodyBytes, unknown fields and trailing data.
+// On failure it writes a problem response and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodyBytes))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+	if err == nil {
+		return true
+	}
+
+	if writeTooLarge(w, err) {
+		return false
+	}
+
+	detail := "invalid request body"
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		detail = "unknown field " + field
+	}
+	writeProblem(w, http.StatusBadRequest, CodeInvalidRequestBody, detail)
+	return false
+}
+
+// decodeName decodes a nameRequest and validates the name. On failure it
+// writes a problem response and returns false.
+func decodeName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req nameRequest
+	if !decodeJSON(w, r, &req) {
+		return "", false
+	}
+
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		writeProblem(w, http.StatusBadRequest, CodeNameRequired, "name is required")
+		return "", false
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		writeProblem(w, http.StatusBadRequest, CodeNameTooLong,
+			fmt.Sprintf("name must not exceed %d characters", maxNameLength))
+		return "", false
+	}
+	return name, true
+}
+
+// readCeremonyBody reads the authenticator response on the finish endpoints,
+// capped at maxCeremonyBodyBytes, and puts it back for the WebAuthn library,
+// whose own errors would hide an oversized body. On failure it writes a
+// problem response and returns false.
+func readCeremonyBody(w http.ResponseWriter, r *http.Request) bool {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCeremonyBodyBytes))
+	if err != nil {
+		if !writeTooLarge(w, err) {
+			writeProblem(w, http.StatusBadRequest, CodeInvalidRequestBody, "invalid request body")
+		}
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return true
+}
+
+// writeTooLarge writes a 413 problem and returns true if err comes from a body
+// over its http.MaxBytesReader limit.
+func writeTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeProblem(w, http.StatusRequestEntityTooLarge, CodeRequestTooLarge,
+		fmt.Sprintf("request body must not exceed %d bytes", maxBytesErr.Limit))
+	return true
+}
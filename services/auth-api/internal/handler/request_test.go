@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeName(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		status int
+		code   string
+	}{
+		{"unknown field", `{"name":"laptop","admin":true}`, http.StatusBadRequest, CodeInvalidRequestBody},
+		{"trailing data", `{"name":"laptop"}{"name":"phone"}`, http.StatusBadRequest, CodeInvalidRequestBody},
+		{"empty body", ``, http.StatusBadRequest, CodeInvalidRequestBody},
+		{"not an object", `["laptop"]`, http.StatusBadRequest, CodeInvalidRequestBody},
+		{"oversized body", `{"name":"` + strings.Repeat("x", maxJSONBodyBytes) + `"}`, http.StatusRequestEntityTooLarge, CodeRequestTooLarge},
+		{"missing name", `{}`, http.StatusBadRequest, CodeNameRequired},
+		{"blank name", `{"name":"  "}`, http.StatusBadRequest, CodeNameRequired},
+		{"long name", `{"name":"` + strings.Repeat("é", maxNameLength+1) + `"}`, http.StatusBadRequest, CodeNameTooLong},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			if _, ok := decodeName(rec, httptest.NewRequest("POST", "/", strings.NewReader(tc.body))); ok {
+				t.Fatal("accepted")
+			}
+			checkProblem(t, rec, tc.status, tc.code)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	name, ok := decodeName(rec, httptest.NewRequest("POST", "/", strings.NewReader(` {"name":" laptop "} `)))
+	if !ok || name != "laptop" {
+		t.Errorf("decodeName = %q, %v, want laptop: %s", name, ok, rec.Body)
+	}
+}
+
+func TestReadCeremonyBody(t *testing.T) {
+	body := `{"id":"` + strings.Repeat("x", maxJSONBodyBytes) + `"}`
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	if !readCeremonyBody(rec, r) {
+		t.Fatalf("rejected a %d-byte body: %s", len(body), rec.Body)
+	}
+	if got, _ := io.ReadAll(r.Body); string(got) != body {
+		t.Error("body not put back for the WebAuthn library")
+	}
+
+	rec = httptest.NewRecorder()
+	r = httptest.NewRequest("POST", "/", strings.NewReader(strings.Repeat("x", maxCeremonyBodyBytes+1)))
+	if readCeremonyBody(rec, r) {
+		t.Fatal("accepted an oversized body")
+	}
+	checkProblem(t, rec, http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+}
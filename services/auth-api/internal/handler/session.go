@@ -1,25 +1,85 @@
 package handler
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"go.local/services/auth-api/internal/store"
 )
 
-func (h *Handler) createAuthSession(w http.ResponseWriter, r *http.Request, displayName string) error {
-	token, err := generateSessionID()
+// SessionStore issues, validates and revokes auth sessions. It is implemented
+// by store.RedisStore and store.CookieStore.
+type SessionStore interface {
+	CreateAuthSession(ctx context.Context, id string, session *store.AuthSession) (string, error)
+	GetAuthSession(ctx context.Context, token string) (*store.AuthSession, error)
+	DeleteAuthSession(ctx context.Context, token string) error
+	RevokeAccountSessions(ctx context.Context, userHandle []byte) error
+}
+
+// sessionRefresher is implemented by session stores whose tokens carry their
+// own expiry, so sliding the session means reissuing the cookie.
+type sessionRefresher interface {
+	RefreshAuthSession(token string, session *store.AuthSession) (string, error)
+}
+
+type sessionContextKey struct{}
+
+// withSession returns a copy of ctx carrying the authenticated session.
+func withSession(ctx context.Context, session *store.AuthSession) context.Context {
+	return context.WithValue(ctx, sessionContextKey{}, session)
+}
+
+// sessionFromContext returns the session stored by RequireSession, or nil.
+func sessionFromContext(ctx context.Context) *store.AuthSession {
+	session, _ := ctx.Value(sessionContextKey{}).(*store.AuthSession)
+	return session
+}
+
+// createAuthSession stores session under a new token and sets the auth_session
+// cookie. CreatedAt is set here.
+func (h *Handler) createAuthSession(w http.ResponseWriter, r *http.Request, session *store.AuthSession) error {
+	session.CreatedAt = time.Now().UTC()
+
+	id, err := generateSessionID()
 	if err != nil {
 		return err
 	}
-
-	session := &store.AuthSession{
-		DisplayName: displayName,
+	token, err := h.Sessions.CreateAuthSession(r.Context(), id, session)
+	if err != nil {
+		return err
 	}
 
-	if err := h.Store.SaveAuthSession(r.Context(), token, session); err != nil {
-		return err
+	h.setSessionCookie(w, token)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "webauthn_session",
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   h.SecureCookie,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   -1,
+	})
+
+	return nil
+}
+
+// refreshAuthSession reissues the auth_session cookie when the session store
+// requires it to slide the expiry. Redis sessions are refreshed on read.
+func (h *Handler) refreshAuthSession(w http.ResponseWriter, token string, session *store.AuthSession) {
+	refresher, ok := h.Sessions.(sessionRefresher)
+	if !ok {
+		return
 	}
+	if token, err := refresher.RefreshAuthSession(token, session); err == nil {
+		h.setSessionCookie(w, token)
+	}
+}
 
+func (h *Handler) setSessionCookie(w http.ResponseWriter, token string) {
 	http.SetCookie(w, &http.Cookie{
 		Name:     "auth_session",
 		Value:    token,
@@ -27,11 +87,13 @@ func (h *Handler) createAuthSession(w http.ResponseWriter, r *http.Request, disp
 		HttpOnly: true,
 		Secure:   h.SecureCookie,
 		SameSite: http.SameSiteLaxMode,
-		MaxAge:   86400,
+		MaxAge:   int(store.AuthSessionMaxAge / time.Second),
 	})
+}
 
+func (h *Handler) clearSessionCookie(w http.ResponseWriter) {
 	http.SetCookie(w, &http.Cookie{
-		Name:     "webauthn_session",
+		Name:     "auth_session",
 		Value:    "",
 		Path:     "/",
 		HttpOnly: true,
@@ -39,26 +101,61 @@ func (h *Handler) createAuthSession(w http.ResponseWriter, r *http.Request, disp
 		SameSite: http.SameSiteLaxMode,
 		MaxAge:   -1,
 	})
+}
 
-	return nil
+// Me returns the identity behind the current session. Must be wrapped in RequireSession.
+func (h *Handler) Me(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		AccountID    string    `json:"account_id"`
+		DisplayName  string    `json:"display_name"`
+		AuthMethod   string    `json:"auth_method"`
+		CredentialID string    `json:"credential_id"`
+		CreatedAt    time.Time `json:"created_at"`
+		ExpiresAt    time.Time `json:"expires_at"`
+	}{
+		AccountID:    base64.RawURLEncoding.EncodeToString(session.UserHandle),
+		DisplayName:  session.DisplayName,
+		AuthMethod:   session.Method,
+		CredentialID: base64.RawURLEncoding.EncodeToString(session.CredentialID),
+		CreatedAt:    session.CreatedAt,
+		ExpiresAt:    session.ExpiresAt.UTC(),
+	})
 }
 
 // Logout deletes the auth session and clears the cookie.
 func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie, err := r.Cookie("auth_session")
 	if err == nil {
-		h.Store.DeleteAuthSession(r.Context(), cookie.Value)
+		h.Sessions.DeleteAuthSession(r.Context(), cookie.Value)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     "auth_session",
-		Value:    "",
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   h.SecureCookie,
-		SameSite: http.SameSiteLaxMode,
-		MaxAge:   -1,
-	})
+	h.clearSessionCookie(w)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// LogoutEverywhere revokes every session belonging to the current account,
+// including this one, and clears the cookie. A session created before
+// sessions recorded their account cannot find the others, so the request is
+// refused and nothing is revoked. Must be wrapped in RequireSession.
+func (h *Handler) LogoutEverywhere(w http.ResponseWriter, r *http.Request) {
+	session := sessionFromContext(r.Context())
+
+	if len(session.UserHandle) == 0 {
+		writeProblem(w, http.StatusConflict, CodeSessionNotLinked,
+			"this session is not linked to an account, so its other sessions cannot be found; nothing was revoked")
+		return
+	}
+
+	if err := h.Sessions.RevokeAccountSessions(r.Context(), session.UserHandle); err != nil {
+		writeInternalError(w)
+		return
+	}
+
+	h.clearSessionCookie(w)
 
 	w.WriteHeader(http.StatusNoContent)
 }
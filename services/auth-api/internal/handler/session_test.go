@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"go.local/services/auth-api/internal/store"
+)
+
+func TestMe(t *testing.T) {
+	h, sessions := newTestHandler()
+	created := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	expires := time.Date(2026, 6, 15, 12, 15, 0, 0, time.UTC)
+	sessions.sessions["alice"] = store.AuthSession{
+		UserHandle:   []byte{0xfb, 0xff, 0x01},
+		DisplayName:  "Alice",
+		Method:       store.MethodPasskeyLogin,
+		CredentialID: []byte{0xfe, 0x02},
+		CreatedAt:    created,
+		ExpiresAt:    expires,
+	}
+
+	rec := serve(h.RequireSession(h.Me), "GET", "/api/me", "", "alice")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	// The fields of the Me schema in api/openapi.json, all required.
+	want := map[string]string{
+		"account_id":    "-_8B",
+		"display_name":  "Alice",
+		"auth_method":   "passkey_login",
+		"credential_id": "_gI",
+		"created_at":    "2026-06-15T12:00:00Z",
+		"expires_at":    "2026-06-15T12:15:00Z",
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("fields %v, want %v", slices.Sorted(maps.Keys(got)), slices.Sorted(maps.Keys(want)))
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("%s = %q, want %q", k, got[k], v)
+			}
+		}
+	}
+}
+
+func TestMeWithoutSession(t *testing.T) {
+	h, _ := newTestHandler()
+	for _, token := range []string{"", "expired"} {
+		rec := serve(h.RequireSession(h.Me), "GET", "/api/me", "", token)
+		checkProblem(t, rec, http.StatusUnauthorized, CodeUnauthorized)
+	}
+}
+
+func TestLogoutEverywhere(t *testing.T) {
+	h, sessions := newTestHandler()
+	sessions.sessions["a1"] = store.AuthSession{UserHandle: []byte("alice")}
+	sessions.sessions["a2"] = store.AuthSession{UserHandle: []byte("alice")}
+	sessions.sessions["b"] = store.AuthSession{UserHandle: []byte("bob")}
+
+	rec := serve(h.RequireSession(h.LogoutEverywhere), "POST", "/api/logout/all", "", "a1")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", rec.Code)
+	}
+	if c := rec.Result().Cookies(); len(c) != 1 || c[0].Name != "auth_session" || c[0].MaxAge >= 0 {
+		t.Errorf("cookies = %v, want auth_session cleared", c)
+	}
+	for token, want := range map[string]bool{"a1": false, "a2": false, "b": true} {
+		if _, ok := sessions.sessions[token]; ok != want {
+			t.Errorf("session %s kept = %v, want %v", token, ok, want)
+		}
+	}
+}
+
+func TestLogoutEverywhereUnlinked(t *testing.T) {
+	h, sessions := newTestHandler()
+	sessions.sessions["old"] = store.AuthSession{DisplayName: "Alice"}
+
+	rec := serve(h.RequireSession(h.LogoutEverywhere), "POST", "/api/logout/all", "", "old")
+	checkProblem(t, rec, http.StatusConflict, CodeSessionNotLinked)
+	if _, ok := sessions.sessions["old"]; !ok {
+		t.Error("session revoked, want nothing revoked")
+	}
+	if c := rec.Result().Cookies(); len(c) != 0 {
+		t.Errorf("cookies = %v, want the cookie left alone", c)
+	}
+}
@@ -2,24 +2,30 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgtype"
 	"go.local/services/auth-api/internal/db"
 )
 
+// RequireSession rejects requests without a valid auth_session cookie and
+// makes the session available to next via the request context.
 func (h *Handler) RequireSession(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		cookie, err := r.Cookie("auth_session")
 		if err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeProblem(w, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
 			return
 		}
-		if _, err := h.Store.GetAuthSession(r.Context(), cookie.Value); err != nil {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		session, err := h.Sessions.GetAuthSession(r.Context(), cookie.Value)
+		if err != nil {
+			writeProblem(w, http.StatusUnauthorized, CodeUnauthorized, "unauthorized")
 			return
 		}
-		next(w, r)
+		h.refreshAuthSession(w, cookie.Value, session)
+		next(w, r.WithContext(withSession(r.Context(), session)))
 	}
 }
 
@@ -27,7 +33,7 @@ func (h *Handler) RequireSession(next http.HandlerFunc) http.HandlerFunc {
 func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
 	tokens, err := h.Queries.ListAPITokens(r.Context())
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
@@ -37,30 +43,23 @@ func (h *Handler) ListTokens(w http.ResponseWriter, r *http.Request) {
 
 // CreateToken generates a new API token.
 func (h *Handler) CreateToken(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	name, ok := decodeName(w, r)
+	if !ok {
 		return
 	}
 
 	token, err := generateSessionID()
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
 	apiToken, err := h.Queries.CreateAPIToken(r.Context(), db.CreateAPITokenParams{
-		Name:  req.Name,
+		Name:  name,
 		Token: token,
 	})
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
@@ -75,28 +74,25 @@ func (h *Handler) UpdateToken(w http.ResponseWriter, r *http.Request) {
 
 	var id pgtype.UUID
 	if err := id.Scan(idStr); err != nil {
-		http.Error(w, "invalid token id", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeInvalidTokenID, "invalid token id")
 		return
 	}
 
-	var req struct {
-		Name string `json:"name"`
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "invalid request body", http.StatusBadRequest)
-		return
-	}
-	if req.Name == "" {
-		http.Error(w, "name is required", http.StatusBadRequest)
+	name, ok := decodeName(w, r)
+	if !ok {
 		return
 	}
 
 	apiToken, err := h.Queries.UpdateAPIToken(r.Context(), db.UpdateAPITokenParams{
 		ID:   id,
-		Name: req.Name,
+		Name: name,
 	})
+	if errors.Is(err, pgx.ErrNoRows) {
+		writeProblem(w, http.StatusNotFound, CodeTokenNotFound, "token not found")
+		return
+	}
 	if err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
@@ -110,12 +106,12 @@ func (h *Handler) DeleteToken(w http.ResponseWriter, r *http.Request) {
 
 	var id pgtype.UUID
 	if err := id.Scan(idStr); err != nil {
-		http.Error(w, "invalid token id", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, CodeInvalidTokenID, "invalid token id")
 		return
 	}
 
 	if err := h.Queries.DeleteAPIToken(r.Context(), id); err != nil {
-		http.Error(w, "internal error", http.StatusInternalServerError)
+		writeInternalError(w)
 		return
 	}
 
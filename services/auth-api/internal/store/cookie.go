@@ -0,0 +1,235 @@
+package store
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+/*
+Cookie sessions carry the auth session itself in the cookie, encrypted and
+authenticated with AES-256-GCM, so validating one needs no Redis round trip
+beyond a revocation check. Sessions slide like Redis sessions by reissuing
+the cookie with a new expiry on each access, up to AuthSessionMaxAge.
+
+Logout and account-wide revocation write to a revocation list in Redis whose
+entries live as long as the longest possible session. If Redis is unreachable
+the revocation check fails, and with it every session, unless the store was
+created to fail open: then the check is skipped, so an outage does not log
+everyone out but revoked cookies are accepted until Redis is back.
+*/
+
+// cookieVersion prefixes every encoded cookie so the format can change later.
+const cookieVersion = 1
+
+var (
+	ErrSessionInvalid = errors.New("store: session cookie invalid")
+	ErrSessionExpired = errors.New("store: session expired")
+	ErrSessionRevoked = errors.New("store: session revoked")
+
+	// ErrRevocationUnavailable is returned for every session while the
+	// revocation list cannot be read, unless the store fails open.
+	ErrRevocationUnavailable = errors.New("store: revocation list unavailable")
+)
+
+// cookiePayload is the plaintext sealed inside a session cookie.
+type cookiePayload struct {
+	ID        string      `json:"id"`
+	Session   AuthSession `json:"session"`
+	ExpiresAt time.Time   `json:"expires_at"`
+}
+
+type cookieKey struct {
+	id   [4]byte
+	aead cipher.AEAD
+}
+
+// CookieStore issues and validates self-contained session cookies.
+type CookieStore struct {
+	client   *redis.Client
+	keys     []cookieKey
+	failOpen bool
+
+	revocationDown atomic.Bool // the last revocation check failed; logged once
+}
+
+// NewCookieStore returns a CookieStore that encrypts with keys[0] and accepts
+// cookies encrypted with any of keys, so old keys can be retired gracefully.
+// Each key must be 32 bytes. With failOpen, sessions are accepted without a
+// revocation check while Redis is unreachable.
+func NewCookieStore(client *redis.Client, keys [][]byte, failOpen bool) (*CookieStore, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("store: at least one session key is required")
+	}
+	s := &CookieStore{client: client, failOpen: failOpen}
+	for i, key := range keys {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("store: session key %d is %d bytes, want 32", i+1, len(key))
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		s.keys = append(s.keys, cookieKey{id: [4]byte(sum[:4]), aead: aead})
+	}
+	return s, nil
+}
+
+// CreateAuthSession seals session into a cookie value. id, a new random
+// token, identifies the session for revocation.
+func (s *CookieStore) CreateAuthSession(ctx context.Context, id string, session *AuthSession) (string, error) {
+	return s.seal(&cookiePayload{
+		ID:        id,
+		Session:   *session,
+		ExpiresAt: time.Now().Add(AuthSessionTTL),
+	})
+}
+
+func (s *CookieStore) GetAuthSession(ctx context.Context, token string) (*AuthSession, error) {
+	p, err := s.open(token)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(p.ExpiresAt) {
+		return nil, ErrSessionExpired
+	}
+
+	vals, err := s.client.MGet(ctx, revokedSessionKey(p.ID), revokedAccountKey(p.Session.UserHandle)).Result()
+	if err != nil {
+		if !s.revocationDown.Swap(true) {
+			if s.failOpen {
+				log.Printf("Session revocation check failed, accepting sessions without it: %v", err)
+			} else {
+				log.Printf("Session revocation check failed, rejecting sessions: %v", err)
+			}
+		}
+		if !s.failOpen {
+			return nil, ErrRevocationUnavailable
+		}
+	} else {
+		if s.revocationDown.Swap(false) {
+			log.Println("Session revocation check recovered")
+		}
+		if vals[0] != nil {
+			return nil, ErrSessionRevoked
+		}
+		if v, ok := vals[1].(string); ok {
+			revokedAt, _ := strconv.ParseInt(v, 10, 64)
+			if !p.Session.CreatedAt.After(time.Unix(0, revokedAt)) {
+				return nil, ErrSessionRevoked
+			}
+		}
+	}
+
+	session := p.Session
+	session.ExpiresAt = p.ExpiresAt
+	return &session, nil
+}
+
+// RefreshAuthSession reissues the cookie for token with a renewed sliding
+// expiry, capped at AuthSessionMaxAge after the session was created. It
+// returns the new cookie value and updates session.ExpiresAt.
+func (s *CookieStore) RefreshAuthSession(token string, session *AuthSession) (string, error) {
+	p, err := s.open(token)
+	if err != nil {
+		return "", err
+	}
+	p.ExpiresAt = time.Now().Add(AuthSessionTTL)
+	if maxExpiry := p.Session.CreatedAt.Add(AuthSessionMaxAge); p.ExpiresAt.After(maxExpiry) {
+		p.ExpiresAt = maxExpiry
+	}
+	session.ExpiresAt = p.ExpiresAt
+	return s.seal(p)
+}
+
+// DeleteAuthSession adds the session to the revocation list. Invalid or
+// expired cookies are ignored.
+func (s *CookieStore) DeleteAuthSession(ctx context.Context, token string) error {
+	p, err := s.open(token)
+	if err != nil {
+		return nil
+	}
+	ttl := time.Until(p.Session.CreatedAt.Add(AuthSessionMaxAge))
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(ctx, revokedSessionKey(p.ID), 1, ttl).Err()
+}
+
+// RevokeAccountSessions revokes every session for the account created up to now.
+func (s *CookieStore) RevokeAccountSessions(ctx context.Context, userHandle []byte) error {
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	return s.client.Set(ctx, revokedAccountKey(userHandle), now, AuthSessionMaxAge).Err()
+}
+
+func (s *CookieStore) seal(p *cookiePayload) (string, error) {
+	plaintext, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	key := s.keys[0]
+
+	// Layout: version (1) | key ID (4) | nonce | ciphertext. The version and
+	// key ID are authenticated as additional data.
+	ad := append([]byte{cookieVersion}, key.id[:]...)
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	out := append(append([]byte{}, ad...), nonce...)
+	out = key.aead.Seal(out, nonce, plaintext, ad)
+	return base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+func (s *CookieStore) open(token string) (*cookiePayload, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) < 5 || b[0] != cookieVersion {
+		return nil, ErrSessionInvalid
+	}
+	ad, rest := b[:5], b[5:]
+
+	for _, key := range s.keys {
+		if [4]byte(ad[1:5]) != key.id {
+			continue
+		}
+		nonceSize := key.aead.NonceSize()
+		if len(rest) < nonceSize {
+			return nil, ErrSessionInvalid
+		}
+		plaintext, err := key.aead.Open(nil, rest[:nonceSize], rest[nonceSize:], ad)
+		if err != nil {
+			return nil, ErrSessionInvalid
+		}
+		var p cookiePayload
+		if err := json.Unmarshal(plaintext, &p); err != nil {
+			return nil, ErrSessionInvalid
+		}
+		return &p, nil
+	}
+	return nil, ErrSessionInvalid
+}
+
+func revokedSessionKey(id string) string {
+	return fmt.Sprintf("auth:revoked:session:%s", id)
+}
+
+func revokedAccountKey(userHandle []byte) string {
+	return fmt.Sprintf("auth:revoked:account:%x", userHandle)
+}
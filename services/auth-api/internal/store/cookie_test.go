@@ -0,0 +1,178 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestCookieStore(t *testing.T, client *redis.Client, failOpen bool, keys ...[]byte) *CookieStore {
+	t.Helper()
+	s, err := NewCookieStore(client, keys, failOpen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNewCookieStoreKeys(t *testing.T) {
+	if _, err := NewCookieStore(nil, nil, false); err == nil {
+		t.Error("no keys: want an error")
+	}
+	if _, err := NewCookieStore(nil, [][]byte{make([]byte, 16)}, false); err == nil {
+		t.Error("16-byte key: want an error")
+	}
+}
+
+func TestCookieSessionRoundTrip(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := newTestCookieStore(t, client, false, testKey(1))
+	ctx := context.Background()
+
+	created := time.Now().UTC()
+	token, err := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), DisplayName: "Alice", CreatedAt: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetAuthSession(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "Alice" || !bytes.Equal(got.UserHandle, []byte("alice")) || !got.CreatedAt.Equal(created) {
+		t.Errorf("got %+v", got)
+	}
+	if d := time.Until(got.ExpiresAt); d < AuthSessionTTL-time.Minute || d > AuthSessionTTL {
+		t.Errorf("ExpiresAt is %s away, want about %s", d, AuthSessionTTL)
+	}
+}
+
+func TestCookieTampering(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := newTestCookieStore(t, client, false, testKey(1))
+	ctx := context.Background()
+
+	token, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+	b, _ := base64.RawURLEncoding.DecodeString(token)
+
+	flipped := bytes.Clone(b)
+	flipped[len(flipped)-1] ^= 1
+	badVersion := bytes.Clone(b)
+	badVersion[0] = cookieVersion + 1
+
+	for name, token := range map[string]string{
+		"ciphertext": base64.RawURLEncoding.EncodeToString(flipped),
+		"version":    base64.RawURLEncoding.EncodeToString(badVersion),
+		"truncated":  base64.RawURLEncoding.EncodeToString(b[:8]),
+		"not base64": "!!!",
+		"empty":      "",
+	} {
+		if _, err := s.GetAuthSession(ctx, token); !errors.Is(err, ErrSessionInvalid) {
+			t.Errorf("%s: err = %v, want ErrSessionInvalid", name, err)
+		}
+	}
+}
+
+func TestCookieKeyRotation(t *testing.T) {
+	_, client := newFakeRedis(t)
+	ctx := context.Background()
+	oldKey, newKey := testKey(1), testKey(2)
+
+	old := newTestCookieStore(t, client, false, oldKey)
+	token, _ := old.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+
+	// The new key is prepended; cookies under the old key stay valid.
+	rotated := newTestCookieStore(t, client, false, newKey, oldKey)
+	if _, err := rotated.GetAuthSession(ctx, token); err != nil {
+		t.Fatalf("old cookie after rotation: %v", err)
+	}
+
+	// Refreshing reissues the cookie under the new key.
+	session := &AuthSession{}
+	refreshed, err := rotated.RefreshAuthSession(token, session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	retired := newTestCookieStore(t, client, false, newKey)
+	if _, err := retired.GetAuthSession(ctx, refreshed); err != nil {
+		t.Errorf("refreshed cookie after retiring the old key: %v", err)
+	}
+	if _, err := retired.GetAuthSession(ctx, token); !errors.Is(err, ErrSessionInvalid) {
+		t.Errorf("old cookie after retiring the old key: err = %v, want ErrSessionInvalid", err)
+	}
+}
+
+func TestCookieRefreshCappedAtMaxAge(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := newTestCookieStore(t, client, false, testKey(1))
+	ctx := context.Background()
+
+	created := time.Now().Add(-AuthSessionMaxAge + 5*time.Minute)
+	token, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: created})
+
+	session := &AuthSession{}
+	if _, err := s.RefreshAuthSession(token, session); err != nil {
+		t.Fatal(err)
+	}
+	if want := created.Add(AuthSessionMaxAge); !session.ExpiresAt.Equal(want) {
+		t.Errorf("ExpiresAt = %s, want the absolute cap %s", session.ExpiresAt, want)
+	}
+}
+
+func TestCookieRevocation(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := newTestCookieStore(t, client, false, testKey(1))
+	ctx := context.Background()
+
+	loggedOut, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+	other, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+	if err := s.DeleteAuthSession(ctx, loggedOut); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, loggedOut); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("logged-out session: err = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := s.GetAuthSession(ctx, other); err != nil {
+		t.Errorf("other session after logout: %v", err)
+	}
+
+	if err := s.RevokeAccountSessions(ctx, []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, other); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("session after account revocation: err = %v, want ErrSessionRevoked", err)
+	}
+
+	// Sessions created after the revocation are valid.
+	time.Sleep(time.Millisecond)
+	fresh, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+	if _, err := s.GetAuthSession(ctx, fresh); err != nil {
+		t.Errorf("session created after revocation: %v", err)
+	}
+}
+
+func TestCookieRevocationUnavailable(t *testing.T) {
+	ctx := context.Background()
+	down := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", Protocol: 2, DisableIdentity: true, MaxRetries: -1, DialTimeout: 100 * time.Millisecond})
+	defer down.Close()
+
+	closed := newTestCookieStore(t, down, false, testKey(1))
+	token, _ := closed.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), CreatedAt: time.Now()})
+	if _, err := closed.GetAuthSession(ctx, token); !errors.Is(err, ErrRevocationUnavailable) {
+		t.Errorf("fail closed: err = %v, want ErrRevocationUnavailable", err)
+	}
+
+	open := newTestCookieStore(t, down, true, testKey(1))
+	if _, err := open.GetAuthSession(ctx, token); err != nil {
+		t.Errorf("fail open: %v", err)
+	}
+}
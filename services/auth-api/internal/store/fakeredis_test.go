@@ -0,0 +1,302 @@
+package store
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis is an in-process server speaking just enough RESP2 for the
+// commands the stores use.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	zsets   map[string]map[string]float64
+	expires map[string]time.Time
+}
+
+type fakeValue struct {
+	kind  byte // '+', '-', ':', '$', '*'
+	str   string
+	n     int64
+	nil   bool
+	items []fakeValue
+}
+
+func (v fakeValue) write(w *bufio.Writer) {
+	switch v.kind {
+	case '+', '-':
+		fmt.Fprintf(w, "%c%s\r\n", v.kind, v.str)
+	case ':':
+		fmt.Fprintf(w, ":%d\r\n", v.n)
+	case '$':
+		if v.nil {
+			w.WriteString("$-1\r\n")
+			return
+		}
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v.str), v.str)
+	case '*':
+		fmt.Fprintf(w, "*%d\r\n", len(v.items))
+		for _, item := range v.items {
+			item.write(w)
+		}
+	}
+}
+
+func ok() fakeValue                  { return fakeValue{kind: '+', str: "OK"} }
+func errValue(s string) fakeValue    { return fakeValue{kind: '-', str: s} }
+func intValue(n int) fakeValue       { return fakeValue{kind: ':', n: int64(n)} }
+func bulk(s string) fakeValue        { return fakeValue{kind: '$', str: s} }
+func nilBulk() fakeValue             { return fakeValue{kind: '$', nil: true} }
+func array(v ...fakeValue) fakeValue { return fakeValue{kind: '*', items: v} }
+
+// newFakeRedis starts a fakeRedis and returns a client connected to it.
+func newFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		zsets:   make(map[string]map[string]float64),
+		expires: make(map[string]time.Time),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), Protocol: 2, DisableIdentity: true})
+	t.Cleanup(func() {
+		client.Close()
+		ln.Close()
+	})
+	return f, client
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	var queue [][]string
+	multi := false
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch name := strings.ToUpper(args[0]); {
+		case name == "MULTI":
+			multi = true
+			ok().write(w)
+		case name == "EXEC":
+			var results []fakeValue
+			for _, cmd := range queue {
+				results = append(results, f.do(cmd))
+			}
+			queue, multi = nil, false
+			array(results...).write(w)
+		case multi:
+			queue = append(queue, args)
+			fakeValue{kind: '+', str: "QUEUED"}.write(w)
+		default:
+			f.do(args).write(w)
+		}
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("want array, got %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, size+2)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		args[i] = string(b[:size])
+	}
+	return args, nil
+}
+
+// expire drops key if its TTL has passed. f.mu must be held.
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expires[key]; ok && !time.Now().Before(at) {
+		delete(f.strings, key)
+		delete(f.zsets, key)
+		delete(f.expires, key)
+	}
+}
+
+func (f *fakeRedis) del(key string) bool {
+	_, s := f.strings[key]
+	_, set := f.zsets[key]
+	delete(f.strings, key)
+	delete(f.zsets, key)
+	delete(f.expires, key)
+	return s || set
+}
+
+func (f *fakeRedis) do(args []string) fakeValue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range args[1:] {
+		f.expire(key)
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return fakeValue{kind: '+', str: "PONG"}
+	case "CLIENT", "SELECT":
+		return ok()
+	case "GET":
+		v, ok := f.strings[args[1]]
+		if !ok {
+			return nilBulk()
+		}
+		return bulk(v)
+	case "GETDEL":
+		v, ok := f.strings[args[1]]
+		if !ok {
+			return nilBulk()
+		}
+		f.del(args[1])
+		return bulk(v)
+	case "SET":
+		f.del(args[1])
+		f.strings[args[1]] = args[2]
+		if len(args) == 5 {
+			n, _ := strconv.Atoi(args[4])
+			unit := time.Second
+			if strings.ToUpper(args[3]) == "PX" {
+				unit = time.Millisecond
+			}
+			f.expires[args[1]] = time.Now().Add(time.Duration(n) * unit)
+		}
+		return ok()
+	case "MGET":
+		var vals []fakeValue
+		for _, key := range args[1:] {
+			if v, ok := f.strings[key]; ok {
+				vals = append(vals, bulk(v))
+			} else {
+				vals = append(vals, nilBulk())
+			}
+		}
+		return array(vals...)
+	case "DEL":
+		var n int
+		for _, key := range args[1:] {
+			if f.del(key) {
+				n++
+			}
+		}
+		return intValue(n)
+	case "EXPIRE":
+		_, s := f.strings[args[1]]
+		_, set := f.zsets[args[1]]
+		if !s && !set {
+			return intValue(0)
+		}
+		n, _ := strconv.Atoi(args[2])
+		f.expires[args[1]] = time.Now().Add(time.Duration(n) * time.Second)
+		return intValue(1)
+	case "TTL":
+		at, ok := f.expires[args[1]]
+		if !ok {
+			return intValue(-1)
+		}
+		return intValue(int(time.Until(at).Round(time.Second).Seconds()))
+	case "ZADD":
+		set := f.zsets[args[1]]
+		if set == nil {
+			set = make(map[string]float64)
+			f.zsets[args[1]] = set
+		}
+		var n int
+		for i := 2; i+1 < len(args); i += 2 {
+			score, _ := strconv.ParseFloat(args[i], 64)
+			if _, ok := set[args[i+1]]; !ok {
+				n++
+			}
+			set[args[i+1]] = score
+		}
+		return intValue(n)
+	case "ZREM":
+		var n int
+		for _, m := range args[2:] {
+			if _, ok := f.zsets[args[1]][m]; ok {
+				delete(f.zsets[args[1]], m)
+				n++
+			}
+		}
+		return intValue(n)
+	case "ZREMRANGEBYSCORE":
+		lo, _ := strconv.ParseFloat(args[2], 64)
+		hi, _ := strconv.ParseFloat(args[3], 64)
+		var n int
+		for m, score := range f.zsets[args[1]] {
+			if score >= lo && score <= hi {
+				delete(f.zsets[args[1]], m)
+				n++
+			}
+		}
+		return intValue(n)
+	case "ZRANGE":
+		// Only the whole set, in score order, is supported.
+		set := f.zsets[args[1]]
+		members := slices.SortedFunc(maps.Keys(set), func(a, b string) int {
+			return cmp.Or(cmp.Compare(set[a], set[b]), strings.Compare(a, b))
+		})
+		var vals []fakeValue
+		for _, m := range members {
+			vals = append(vals, bulk(m))
+		}
+		return array(vals...)
+	default:
+		return errValue("ERR unknown command '" + args[0] + "'")
+	}
+}
+
+// setTTL sets the TTL of an existing key directly.
+func (f *fakeRedis) setTTL(key string, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expires[key] = time.Now().Add(d)
+}
@@ -3,7 +3,9 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
@@ -83,19 +85,53 @@ func (s *RedisStore) DeleteRegistrationSession(ctx context.Context, sessionID st
 
 /*
 Auth sessions persist user identity after a successful registration or login.
-Sessions have a 15-minute sliding TTL that refreshes on each access.
+Sessions have a 15-minute sliding TTL that refreshes on each access. Each
+account's session tokens are indexed so they can all be revoked at once. The
+index is a sorted set scored by each session's expiry: expired tokens are
+trimmed whenever a session is created or read, and the index itself expires
+with the last of its sessions.
 */
 
+const (
+	// AuthSessionTTL is the sliding lifetime of an auth session.
+	AuthSessionTTL = 15 * time.Minute
+
+	// AuthSessionMaxAge is the absolute lifetime of an auth session cookie.
+	AuthSessionMaxAge = 24 * time.Hour
+)
+
+// Authentication methods recorded on an AuthSession.
+const (
+	MethodPasskeyRegistration = "passkey_registration"
+	MethodPasskeyLogin        = "passkey_login"
+)
+
 type AuthSession struct {
-	DisplayName string `json:"display_name"`
+	UserHandle   []byte    `json:"user_handle"`
+	DisplayName  string    `json:"display_name"`
+	Method       string    `json:"method"`
+	CredentialID []byte    `json:"credential_id"`
+	CreatedAt    time.Time `json:"created_at"`
+
+	// ExpiresAt is set when the session is read; it is not stored.
+	ExpiresAt time.Time `json:"-"`
 }
 
-func (s *RedisStore) SaveAuthSession(ctx context.Context, token string, session *AuthSession) error {
+// CreateAuthSession stores session under id, a new random token, and returns
+// the token.
+func (s *RedisStore) CreateAuthSession(ctx context.Context, id string, session *AuthSession) (string, error) {
 	b, err := json.Marshal(session)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return s.client.Set(ctx, authSessionKey(token), b, 15*time.Minute).Err()
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, authSessionKey(id), b, AuthSessionTTL)
+	indexSession(ctx, pipe, session.UserHandle, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return id, nil
 }
 
 func (s *RedisStore) GetAuthSession(ctx context.Context, token string) (*AuthSession, error) {
@@ -104,22 +140,75 @@ func (s *RedisStore) GetAuthSession(ctx context.Context, token string) (*AuthSes
 	if err != nil {
 		return nil, err
 	}
-	s.client.Expire(ctx, key, 15*time.Minute)
 	var session AuthSession
 	if err := json.Unmarshal(b, &session); err != nil {
 		return nil, err
 	}
+	pipe := s.client.Pipeline()
+	pipe.Expire(ctx, key, AuthSessionTTL)
+	indexSession(ctx, pipe, session.UserHandle, token)
+	pipe.Exec(ctx)
+	session.ExpiresAt = time.Now().Add(AuthSessionTTL)
 	return &session, nil
 }
 
+// indexSession queues commands that trim expired tokens from the account's
+// index and record token as expiring AuthSessionTTL from now. Every other
+// token in the index expires sooner, so the index expires with it. Sessions
+// without a user handle belong to no account and are not indexed.
+func indexSession(ctx context.Context, pipe redis.Pipeliner, userHandle []byte, token string) {
+	if len(userHandle) == 0 {
+		return
+	}
+	now := time.Now()
+	accountKey := accountSessionsKey(userHandle)
+	pipe.ZRemRangeByScore(ctx, accountKey, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.ZAdd(ctx, accountKey, redis.Z{Score: float64(now.Add(AuthSessionTTL).Unix()), Member: token})
+	pipe.Expire(ctx, accountKey, AuthSessionTTL)
+}
+
+// DeleteAuthSession deletes the session and removes it from its account's
+// index. Unknown tokens are ignored.
 func (s *RedisStore) DeleteAuthSession(ctx context.Context, token string) error {
-	return s.client.Del(ctx, authSessionKey(token)).Err()
+	b, err := s.client.GetDel(ctx, authSessionKey(token)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var session AuthSession
+	if err := json.Unmarshal(b, &session); err != nil {
+		return err
+	}
+	if len(session.UserHandle) == 0 {
+		return nil
+	}
+	return s.client.ZRem(ctx, accountSessionsKey(session.UserHandle), token).Err()
+}
+
+// RevokeAccountSessions deletes every session belonging to the account.
+func (s *RedisStore) RevokeAccountSessions(ctx context.Context, userHandle []byte) error {
+	accountKey := accountSessionsKey(userHandle)
+	tokens, err := s.client.ZRange(ctx, accountKey, 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	keys := []string{accountKey}
+	for _, token := range tokens {
+		keys = append(keys, authSessionKey(token))
+	}
+	return s.client.Del(ctx, keys...).Err()
 }
 
 func authSessionKey(token string) string {
 	return fmt.Sprintf("auth:session:%s", token)
 }
 
+func accountSessionsKey(userHandle []byte) string {
+	return fmt.Sprintf("auth:account:%x:sessions", userHandle)
+}
+
 func registrationKey(sessionID string) string {
 	return fmt.Sprintf("registration:session:%s", sessionID)
 }
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisAuthSession(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+
+	token, err := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice"), DisplayName: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetAuthSession(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DisplayName != "Alice" {
+		t.Errorf("DisplayName = %q, want Alice", got.DisplayName)
+	}
+	if d := time.Until(got.ExpiresAt); d < AuthSessionTTL-time.Minute || d > AuthSessionTTL {
+		t.Errorf("ExpiresAt is %s away, want about %s", d, AuthSessionTTL)
+	}
+
+	if err := s.DeleteAuthSession(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, token); err == nil {
+		t.Error("deleted session is still valid")
+	}
+	if err := s.DeleteAuthSession(ctx, token); err != nil {
+		t.Errorf("deleting an unknown session: %v", err)
+	}
+}
+
+func TestRedisDeleteRemovesFromIndex(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+	user := []byte("alice")
+
+	keep, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: user})
+	drop, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: user})
+	if err := s.DeleteAuthSession(ctx, drop); err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := client.ZRange(ctx, accountSessionsKey(user), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != keep {
+		t.Errorf("index = %v, want only the remaining session", members)
+	}
+}
+
+func TestRedisGetExtendsIndex(t *testing.T) {
+	f, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+	user := []byte("alice")
+
+	token, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: user})
+
+	// As if the session were about to expire.
+	f.setTTL(accountSessionsKey(user), time.Minute)
+	if _, err := s.GetAuthSession(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := client.TTL(ctx, accountSessionsKey(user)).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl < AuthSessionTTL-time.Minute {
+		t.Errorf("index TTL = %s after access, want about %s", ttl, AuthSessionTTL)
+	}
+
+	if err := s.RevokeAccountSessions(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, token); err == nil {
+		t.Error("revoked session is still valid")
+	}
+}
+
+func TestRedisIndexDropsExpiredSessions(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+	user := []byte("alice")
+	key := accountSessionsKey(user)
+
+	// A session that expired without being deleted.
+	stale := redis.Z{Score: float64(time.Now().Add(-time.Minute).Unix()), Member: "stale"}
+	if err := client.ZAdd(ctx, key, stale).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	token, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: user})
+	members, err := client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != token {
+		t.Errorf("index after create = %v, want only the new session", members)
+	}
+
+	if err := client.ZAdd(ctx, key, stale).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	members, err = client.ZRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != token {
+		t.Errorf("index after read = %v, want only the live session", members)
+	}
+}
+
+func TestRedisUnlinkedSessionNotIndexed(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+
+	token, err := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{DisplayName: "Alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetAuthSession(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	members, err := client.ZRange(ctx, accountSessionsKey(nil), 0, -1).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 0 {
+		t.Errorf("sessions without a user handle share an index: %v", members)
+	}
+	if err := s.DeleteAuthSession(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRedisRevokeAccountSessions(t *testing.T) {
+	_, client := newFakeRedis(t)
+	s := NewRedisStore(client)
+	ctx := context.Background()
+
+	a1, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice")})
+	a2, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("alice")})
+	b, _ := s.CreateAuthSession(ctx, rand.Text(), &AuthSession{UserHandle: []byte("bob")})
+
+	if err := s.RevokeAccountSessions(ctx, []byte("alice")); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range []string{a1, a2} {
+		if _, err := s.GetAuthSession(ctx, token); err == nil {
+			t.Error("revoked session is still valid")
+		}
+	}
+	if _, err := s.GetAuthSession(ctx, b); err != nil {
+		t.Errorf("another account's session was revoked: %v", err)
+	}
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"context"
 	_ "embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -20,6 +26,19 @@ import (
 //go:embed sql/schema.sql
 var schema string
 
+//go:embed api/openapi.json
+var openAPISpec []byte
+
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 15 * time.Second
+	writeTimeout      = 15 * time.Second
+	idleTimeout       = 60 * time.Second
+	shutdownTimeout   = 30 * time.Second
+
+	defaultDrainDelay = 5 * time.Second
+)
+
 func main() {
 	ctx := context.Background()
 
@@ -55,41 +74,133 @@ func main() {
 		log.Fatalf("Failed to initialise WebAuthn: %v", err)
 	}
 
+	redisStore := store.NewRedisStore(rdb)
+
+	sessionMode := os.Getenv("SESSION_MODE")
+	if sessionMode == "" {
+		sessionMode = "redis"
+	}
+	var sessions handler.SessionStore
+	switch sessionMode {
+	case "redis":
+		sessions = redisStore
+	case "cookie":
+		keys, err := parseSessionKeys(env.Required("SESSION_KEYS"))
+		if err != nil {
+			log.Fatalf("Invalid SESSION_KEYS: %v", err)
+		}
+		sessions, err = store.NewCookieStore(rdb, keys, env.Bool("SESSION_REVOCATION_FAIL_OPEN"))
+		if err != nil {
+			log.Fatalf("Invalid SESSION_KEYS: %v", err)
+		}
+	default:
+		log.Fatalf("Invalid SESSION_MODE %q: must be redis or cookie", sessionMode)
+	}
+
 	rpOrigin := rpOrigins[0]
 	h := &handler.Handler{
 		WebAuthn:     webAuthn,
 		Queries:      db.New(pool),
-		Store:        store.NewRedisStore(rdb),
+		Store:        redisStore,
+		Sessions:     sessions,
 		SecureCookie: strings.HasPrefix(rpOrigin, "https://"),
 	}
 
+	health := &handler.Health{Pool: pool, Redis: rdb}
+
 	mux := http.NewServeMux()
-	mux.HandleFunc("POST /api/register/begin", h.BeginPasskeyRegistration)
-	mux.HandleFunc("POST /api/register/finish", h.FinishRegistration)
-	mux.HandleFunc("POST /api/login/begin", h.BeginLogin)
-	mux.HandleFunc("POST /api/login/finish", h.FinishLogin)
-	mux.HandleFunc("POST /api/logout", h.Logout)
-	mux.HandleFunc("GET /api/tokens", h.RequireSession(h.ListTokens))
-	mux.HandleFunc("POST /api/tokens", h.RequireSession(h.CreateToken))
-	mux.HandleFunc("PATCH /api/tokens/{id}", h.RequireSession(h.UpdateToken))
-	mux.HandleFunc("DELETE /api/tokens/{id}", h.RequireSession(h.DeleteToken))
-	mux.HandleFunc("POST /api/introspect", h.Introspect)
+	routes(mux, h, health)
 
 	addr := ":8081"
 	if v := os.Getenv("ADDR"); v != "" {
 		addr = v
 	}
+	drainDelay := env.NonNegativeDuration("DRAIN_DELAY", defaultDrainDelay)
 
 	log.Println("Configuration:")
-	log.Printf("  ADDR        = %s", addr)
+	log.Printf("  ADDR         = %s", addr)
 	log.Printf("  DATABASE_URL = %s", dbURL)
-	log.Printf("  REDIS_ADDR  = %s", redisAddr)
-	log.Printf("  RP_ID       = %s", rpID)
-	log.Printf("  RP_ORIGINS  = %s", strings.Join(rpOrigins, ", "))
+	log.Printf("  REDIS_ADDR   = %s", redisAddr)
+	log.Printf("  RP_ID        = %s", rpID)
+	log.Printf("  RP_ORIGINS   = %s", strings.Join(rpOrigins, ", "))
+	log.Printf("  SESSION_MODE = %s", sessionMode)
+	log.Printf("  DRAIN_DELAY  = %s", drainDelay)
 	log.Println()
 
-	log.Printf("Auth server listening on %s", addr)
-	if err := http.ListenAndServe(addr, handler.CORS(rpOrigins, mux)); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler.CORS(rpOrigins, mux),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Printf("Auth server listening on %s", addr)
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-sigCtx.Done()
+	stop()
+
+	// Fail readiness and keep serving for DRAIN_DELAY, so load balancers see
+	// it and stop routing here, then let in-flight requests finish. Ceremony
+	// state lives in Redis, so a ceremony begun here can be finished by
+	// another instance.
+	log.Printf("Shutting down, failing readiness for %s", drainDelay)
+	health.SetDraining()
+	time.Sleep(drainDelay)
+	log.Println("Draining in-flight requests")
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Graceful shutdown incomplete: %v", err)
 	}
+	log.Println("Shut down")
+}
+
+// parseSessionKeys decodes a comma-separated list of base64-encoded 32-byte
+// keys. The first key encrypts new cookies; the rest are accepted for decryption.
+func parseSessionKeys(v string) ([][]byte, error) {
+	var keys [][]byte
+	for i, s := range strings.Split(v, ",") {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// router is the part of http.ServeMux that routes uses.
+type router interface {
+	HandleFunc(pattern string, handler func(http.ResponseWriter, *http.Request))
+}
+
+// routes registers every endpoint on mux. Each must be documented in
+// api/openapi.json.
+func routes(mux router, h *handler.Handler, health *handler.Health) {
+	mux.HandleFunc("GET /healthz", health.Liveness)
+	mux.HandleFunc("GET /readyz", health.Readiness)
+	mux.HandleFunc("POST /api/register/begin", h.BeginPasskeyRegistration)
+	mux.HandleFunc("POST /api/register/finish", h.FinishRegistration)
+	mux.HandleFunc("POST /api/login/begin", h.BeginLogin)
+	mux.HandleFunc("POST /api/login/finish", h.FinishLogin)
+	mux.HandleFunc("POST /api/logout", h.Logout)
+	mux.HandleFunc("POST /api/logout/all", h.RequireSession(h.LogoutEverywhere))
+	mux.HandleFunc("GET /api/me", h.RequireSession(h.Me))
+	mux.HandleFunc("GET /api/tokens", h.RequireSession(h.ListTokens))
+	mux.HandleFunc("POST /api/tokens", h.RequireSession(h.CreateToken))
+	mux.HandleFunc("PATCH /api/tokens/{id}", h.RequireSession(h.UpdateToken))
+	mux.HandleFunc("DELETE /api/tokens/{id}", h.RequireSession(h.DeleteToken))
+	mux.HandleFunc("POST /api/introspect", h.Introspect)
+	mux.HandleFunc("GET /api/openapi.json", handler.OpenAPI(openAPISpec))
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.local/services/auth-api/internal/handler"
+)
+
+// patterns records the patterns registered on it.
+type patterns []string
+
+func (p *patterns) HandleFunc(pattern string, _ func(http.ResponseWriter, *http.Request)) {
+	*p = append(*p, pattern)
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatal(err)
+	}
+	var documented []string
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			switch method {
+			case "parameters", "summary", "description", "servers":
+				continue // path item fields, not operations
+			}
+			documented = append(documented, strings.ToUpper(method)+" "+path)
+		}
+	}
+
+	var registered patterns
+	routes(&registered, &handler.Handler{}, &handler.Health{})
+	for _, p := range registered {
+		if !slices.Contains(documented, p) {
+			t.Errorf("%s is not in api/openapi.json", p)
+		}
+	}
+	for _, p := range documented {
+		if !slices.Contains(registered, p) {
+			t.Errorf("%s is in api/openapi.json but not served", p)
+		}
+	}
+}
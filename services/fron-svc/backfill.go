@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+)
+
+const (
+	// catchUpMinGap is the shortest gap since the last poll that is worth
+	// backfilling at startup; the archive only has 5-minute resolution.
+	catchUpMinGap = 10 * time.Minute
+
+	// checkpointInterval is how often poll checkpoints are saved to disk.
+	checkpointInterval = time.Minute
+
+	// backfillBatch is how many points are written per request.
+	backfillBatch = 500
+
+	defaultBackfillMaxDays = 7
+)
+
+// archiveChannels are the archive channels backfilled into the inverter
+// measurement. Channels an inverter does not record are simply absent.
+var archiveChannels = []string{
+	fronius.ChannelEnergyProduced,
+	fronius.ChannelPowerAC,
+	fronius.ChannelVoltageAC,
+	fronius.ChannelVoltageACL2,
+	fronius.ChannelVoltageACL3,
+	fronius.ChannelCurrentAC,
+	fronius.ChannelCurrentACL2,
+	fronius.ChannelCurrentACL3,
+	fronius.ChannelVoltageDC1,
+	fronius.ChannelCurrentDC1,
+	fronius.ChannelVoltageDC2,
+	fronius.ChannelCurrentDC2,
+}
+
+// backfill writes the inverter's archive between from and to, exclusive, as
+// inverter points with their original timestamps. It returns the number of
+// points written.
+func backfill(ctx context.Context, inv inverterConfig, client *fronius.Client, w Sink, from, to time.Time) (int, error) {
+	// The archive is fetched in whole days so day_energy can be summed from
+	// midnight.
+	records, err := client.FetchArchive(ctx, inv.DeviceID, from, to.Add(-time.Second), archiveChannels...)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		batch   []Reading
+		written int
+		day     time.Time
+		dayWh   float64
+	)
+	for _, r := range records {
+		if d := startOfDay(r.Time); !d.Equal(day) {
+			day, dayWh = d, 0
+		}
+		dayWh += r.Values[fronius.ChannelEnergyProduced]
+
+		// Like live polling, nothing is written while the inverter is not
+		// producing.
+		pacW, ok := r.Values[fronius.ChannelPowerAC]
+		if !ok || pacW <= 0 || !r.Time.After(from) || !r.Time.Before(to) {
+			continue
+		}
+
+		batch = append(batch, Reading{
+			Measurement: "inverter",
+			Tags:        map[string]string{"device_id": inv.Name},
+			Fields:      archiveFields(inv, r.Values, pacW, dayWh),
+			Time:        r.Time,
+		})
+		if len(batch) == backfillBatch {
+			if err := w.Write(ctx, batch...); err != nil {
+				return written, err
+			}
+			written += len(batch)
+			batch = batch[:0]
+		}
+	}
+	if len(batch) > 0 {
+		if err := w.Write(ctx, batch...); err != nil {
+			return written, err
+		}
+		written += len(batch)
+	}
+	return written, nil
+}
+
+// archiveFields maps archive channels onto the fields written by live polling.
+func archiveFields(inv inverterConfig, values map[string]float64, pacW, dayWh float64) map[string]interface{} {
+	fields := map[string]interface{}{
+		"pac":         pacW,
+		"pac_kw":      pacW / 1000,
+		"utilisation": (pacW / inv.CapacityW) * 100,
+		"day_energy":  dayWh,
+	}
+	set := func(field, channel string) {
+		if v, ok := values[channel]; ok {
+			fields[field] = v
+		}
+	}
+	set("uac", fronius.ChannelVoltageAC)
+	set("iac", fronius.ChannelCurrentAC)
+	set("udc", fronius.ChannelVoltageDC1)
+	set("idc", fronius.ChannelCurrentDC1)
+
+	if _, ok := values[fronius.ChannelVoltageACL2]; ok {
+		set("uac_l1", fronius.ChannelVoltageAC)
+		set("uac_l2", fronius.ChannelVoltageACL2)
+		set("uac_l3", fronius.ChannelVoltageACL3)
+		set("iac_l1", fronius.ChannelCurrentAC)
+		set("iac_l2", fronius.ChannelCurrentACL2)
+		set("iac_l3", fronius.ChannelCurrentACL3)
+	}
+
+	udc2, ok2 := values[fronius.ChannelVoltageDC2]
+	idc2, okI2 := values[fronius.ChannelCurrentDC2]
+	if ok2 && okI2 {
+		udc1, idc1 := values[fronius.ChannelVoltageDC1], values[fronius.ChannelCurrentDC1]
+		fields["udc_1"], fields["idc_1"], fields["pdc_1"] = udc1, idc1, udc1*idc1
+		fields["udc_2"], fields["idc_2"], fields["pdc_2"] = udc2, idc2, udc2*idc2
+	}
+	return fields
+}
+
+func startOfDay(t time.Time) time.Time {
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if d.Day() != t.Day() {
+		// Clocks skipped midnight and time.Date went back to the day before;
+		// the day starts when the skip ends.
+		_, d = d.ZoneBounds()
+	}
+	return d
+}
+
+// catchUp backfills the gap between an inverter's last successful poll before
+// a restart and now, going back at most maxDays.
+func catchUp(ctx context.Context, inv inverterConfig, client *fronius.Client, w Sink, since, now time.Time, maxDays int) {
+	if since.IsZero() || now.Sub(since) < catchUpMinGap {
+		return
+	}
+	if oldest := now.AddDate(0, 0, -maxDays); since.Before(oldest) {
+		log.Printf("[%s] Last poll was %s, backfilling only the last %d days", inv.Name, since.Format(time.RFC3339), maxDays)
+		since = oldest
+	}
+
+	log.Printf("[%s] Backfilling gap from %s to %s from the archive", inv.Name, since.Format(time.RFC3339), now.Format(time.RFC3339))
+	n, err := backfill(ctx, inv, client, w, since, now)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("[%s] Backfill failed after %d point(s): %v", inv.Name, n, err)
+		return
+	}
+	log.Printf("[%s] Backfilled %d point(s)", inv.Name, n)
+}
+
+// checkpoints records the time of each inverter's last successful poll, so a
+// restart knows which gap to backfill, how far downsampling has got, and the
+// earnings totalled so far today. It is saved to a JSON file.
+type checkpoints struct {
+	path string
+
+	mu       sync.Mutex
+	last     map[string]time.Time
+	earnings *earningsState
+	dirty    bool
+}
+
+// checkpointFile is the layout of the checkpoints file. Files written before
+// earnings were kept hold only the last map.
+type checkpointFile struct {
+	Last     map[string]time.Time `json:"last"`
+	Earnings *earningsState       `json:"earnings,omitempty"`
+}
+
+// loadCheckpoints reads checkpoints from path. A missing file is not an error.
+func loadCheckpoints(path string) (*checkpoints, error) {
+	c := &checkpoints{path: path, last: make(map[string]time.Time)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f checkpointFile
+	if err := json.Unmarshal(b, &f); err == nil && f.Last != nil {
+		c.last, c.earnings = f.Last, f.Earnings
+		return c, nil
+	}
+	if err := json.Unmarshal(b, &c.last); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *checkpoints) get(name string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last[name]
+}
+
+func (c *checkpoints) set(name string, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.last[name] = t
+	c.dirty = true
+}
+
+// getEarnings returns the saved earnings state, or nil if there is none.
+func (c *checkpoints) getEarnings() *earningsState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.earnings
+}
+
+// setEarnings records st, which the caller must not modify afterwards.
+func (c *checkpoints) setEarnings(st *earningsState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.earnings = st
+	c.dirty = true
+}
+
+// run saves checkpoints every checkpointInterval, and once more when ctx is
+// cancelled.
+func (c *checkpoints) run(ctx context.Context) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			c.save()
+			return
+		case <-ticker.C:
+			c.save()
+		}
+	}
+}
+
+// save writes the checkpoints if they changed, replacing the file atomically.
+func (c *checkpoints) save() {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return
+	}
+	b, err := json.Marshal(checkpointFile{Last: c.last, Earnings: c.earnings})
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("Failed to encode checkpoints: %v", err)
+		return
+	}
+
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		log.Printf("Failed to save checkpoints: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		log.Printf("Failed to save checkpoints: %v", err)
+	}
+}
+
+// checkpointsPath returns where checkpoints are kept: beside the spool.
+func checkpointsPath(spoolDir string) string {
+	return filepath.Join(spoolDir, "checkpoints.json")
+}
+
+// backfillCommand implements "fron-svc backfill": it writes the archive of
+// every configured inverter for a range of days straight to the configured
+// sinks, without spooling, then exits.
+func backfillCommand(args []string) {
+	fs := flag.NewFlagSet("backfill", flag.ExitOnError)
+	fromFlag := fs.String("from", "", "first day to backfill, YYYY-MM-DD (required)")
+	toFlag := fs.String("to", "", "last day to backfill, YYYY-MM-DD (default today)")
+	only := fs.String("inverter", "", "backfill only the inverter with this name")
+	fs.Parse(args)
+
+	from, err := time.ParseInLocation(time.DateOnly, *fromFlag, time.Local)
+	if err != nil {
+		log.Fatalf("Invalid --from: want YYYY-MM-DD, got %q", *fromFlag)
+	}
+	to := startOfDay(time.Now())
+	if *toFlag != "" {
+		if to, err = time.ParseInLocation(time.DateOnly, *toFlag, time.Local); err != nil {
+			log.Fatalf("Invalid --to: want YYYY-MM-DD, got %q", *toFlag)
+		}
+	}
+	if to.Before(from) {
+		log.Fatalf("Invalid range: --to %s is before --from %s", to.Format(time.DateOnly), from.Format(time.DateOnly))
+	}
+
+	inverters, err := loadInverters()
+	if err != nil {
+		log.Fatalf("Invalid inverter configuration: %v", err)
+	}
+
+	names, err := sinkNames()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sinks, err := openSinks(names, "", newHealth())
+	if err != nil {
+		log.Fatalf("Failed to open sinks: %v", err)
+	}
+	sink := sinkList(sinks)
+	defer sink.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var matched bool
+	for _, inv := range inverters {
+		if *only != "" && inv.Name != *only {
+			continue
+		}
+		matched = true
+
+		client := fronius.New(inv.URL, &http.Client{Timeout: 4 * time.Second})
+		log.Printf("[%s] Backfilling %s to %s", inv.Name, from.Format(time.DateOnly), to.Format(time.DateOnly))
+		n, err := backfill(ctx, inv, client, sink, from, to.AddDate(0, 0, 1))
+		if err != nil {
+			log.Fatalf("[%s] Backfill failed after %d point(s): %v", inv.Name, n, err)
+		}
+		log.Printf("[%s] Backfilled %d point(s)", inv.Name, n)
+	}
+	if !matched {
+		log.Fatalf("No inverter named %q", *only)
+	}
+
+	// The running service aggregates each period only once, so bring the
+	// downsampled buckets up to date with what was written.
+	if slices.Contains(names, "influxdb") {
+		if ds := newDownsampler(loadInfluxConfig(), nil, nil, 0); ds != nil {
+			defer ds.client.Close()
+			if err := ds.reaggregate(ctx, from, to.AddDate(0, 0, 1), time.Now()); err != nil {
+				log.Fatalf("Downsampling failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+	"go.local/services/fron-svc/internal/fronius/froniustest"
+)
+
+// newArchiveSim returns a simulator whose clock stands at now, and a client
+// and inverter configuration for its DeviceId 1.
+func newArchiveSim(t *testing.T, now time.Time) (*froniustest.Simulator, *fronius.Client, inverterConfig) {
+	t.Helper()
+	sim, srv := froniustest.NewServer(froniustest.Config{Now: func() time.Time { return now }})
+	t.Cleanup(srv.Close)
+	inv := inverterConfig{Name: "roof", URL: srv.URL, DeviceID: 1, CapacityW: 5000}
+	return sim, fronius.New(srv.URL, &http.Client{Timeout: time.Second}), inv
+}
+
+func TestBackfill(t *testing.T) {
+	now := time.Date(2026, 6, 16, 12, 0, 0, 0, time.Local)
+	sim, client, inv := newArchiveSim(t, now)
+	sink := &recordSink{}
+
+	// From midday, so the morning is fetched only to sum day_energy.
+	from := time.Date(2026, 6, 14, 12, 0, 0, 0, time.Local)
+	n, err := backfill(context.Background(), inv, client, sink, from, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := sink.take()
+	readings := got["inverter"]
+	if len(got) != 1 || n != len(readings) {
+		t.Fatalf("wrote %d point(s) in %d measurement(s), want %d inverter readings", n, len(got), len(readings))
+	}
+	// 12:00-20:00, all of the 15th, and 06:00-12:00, every 5 minutes.
+	if want := (8 + 14 + 6) * 12; math.Abs(float64(len(readings)-want)) > 3 {
+		t.Errorf("got %d readings, want about %d", len(readings), want)
+	}
+
+	var last time.Time
+	for _, r := range readings {
+		if !r.Time.After(from) || !r.Time.Before(now) {
+			t.Fatalf("reading at %s outside the range %s to %s", r.Time, from, now)
+		}
+		if !r.Time.After(last) {
+			t.Fatalf("reading at %s out of order", r.Time)
+		}
+		last = r.Time
+		if r.Tags["device_id"] != "roof" {
+			t.Errorf("device_id = %q, want roof", r.Tags["device_id"])
+		}
+		pac := field(t, r, "pac")
+		if pac <= 0 {
+			t.Errorf("%s: pac = %v, want only producing intervals", r.Time, pac)
+		}
+		if want := sim.Power(r.Time); math.Abs(pac-want) > 1 {
+			t.Errorf("%s: pac = %v, want %.0f", r.Time, pac, want)
+		}
+		// day_energy is summed from midnight, so it restarts each day and
+		// includes what was produced before from.
+		if got, want := field(t, r, "day_energy"), sim.DayEnergy(r.Time); math.Abs(got-want) > 1 {
+			t.Errorf("%s: day_energy = %.1f, want %.1f", r.Time.Format(time.DateTime), got, want)
+		}
+		if got, want := field(t, r, "utilisation"), pac/inv.CapacityW*100; !near(got, want) {
+			t.Errorf("%s: utilisation = %v, want %v", r.Time, got, want)
+		}
+		for _, f := range []string{"uac", "iac", "udc", "idc"} {
+			if _, ok := r.Fields[f]; !ok {
+				t.Errorf("%s: field %s missing", r.Time, f)
+			}
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	now := time.Date(2026, 6, 16, 12, 0, 0, 0, time.Local)
+	_, client, inv := newArchiveSim(t, now)
+	ctx := context.Background()
+
+	// Nothing on the very first run, or for a gap the archive cannot fill.
+	for _, since := range []time.Time{{}, now.Add(-catchUpMinGap + time.Minute)} {
+		sink := &recordSink{}
+		catchUp(ctx, inv, client, sink, since, now, 7)
+		if got := sink.take(); len(got) != 0 {
+			t.Errorf("since %s: wrote %d measurement(s), want nothing", since, len(got))
+		}
+	}
+
+	sink := &recordSink{}
+	since := now.Add(-time.Hour)
+	catchUp(ctx, inv, client, sink, since, now, 7)
+	readings := sink.take()["inverter"]
+	if len(readings) < 11 {
+		t.Fatalf("got %d readings for an hour's gap, want 11 or 12", len(readings))
+	}
+	if !readings[0].Time.After(since) {
+		t.Errorf("first reading at %s, want after the last poll at %s", readings[0].Time, since)
+	}
+
+	// A gap longer than maxDays only goes back maxDays.
+	catchUp(ctx, inv, client, sink, now.AddDate(0, 0, -10), now, 1)
+	readings = sink.take()["inverter"]
+	if len(readings) == 0 {
+		t.Fatal("nothing backfilled for a long gap")
+	}
+	if oldest := now.AddDate(0, 0, -1); readings[0].Time.Before(oldest) {
+		t.Errorf("first reading at %s, want no earlier than %s", readings[0].Time, oldest)
+	}
+}
+
+func TestCheckpointsRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	cps, err := loadCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to save until something is set.
+	cps.save()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("saved without changes: %v", err)
+	}
+
+	polled := time.Date(2026, 6, 15, 12, 0, 5, 0, time.Local)
+	day := time.Date(2026, 6, 15, 0, 0, 0, 0, time.Local)
+	cps.set("roof", polled)
+	cps.set("downsample/hourly", day)
+	cps.setEarnings(&earningsState{
+		Metered:  true,
+		Counters: map[string]float64{"inverter,device_id=roof/day_energy": 7000},
+		Times:    map[string]time.Time{"inverter,device_id=roof/day_energy": polled},
+		Day:      day,
+		Total:    map[string]float64{"earnings": 0.2, "savings": 0.3},
+	})
+	cps.save()
+
+	loaded, err := loadCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]time.Time{"roof": polled, "downsample/hourly": day, "garage": {}} {
+		if got := loaded.get(name); !got.Equal(want) {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	st := loaded.getEarnings()
+	if st == nil {
+		t.Fatal("earnings state not saved")
+	}
+	if !st.Metered || !st.Day.Equal(day) || st.Total["savings"] != 0.3 ||
+		st.Counters["inverter,device_id=roof/day_energy"] != 7000 ||
+		!st.Times["inverter,device_id=roof/day_energy"].Equal(polled) {
+		t.Errorf("earnings state = %+v", st)
+	}
+}
+
+func TestLoadLegacyCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	if err := os.WriteFile(path, []byte(`{"roof":"2026-06-15T12:00:00Z"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cps, err := loadCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC); !cps.get("roof").Equal(want) {
+		t.Errorf("roof = %s, want %s", cps.get("roof"), want)
+	}
+	if cps.getEarnings() != nil {
+		t.Error("earnings state read from a file without one")
+	}
+}
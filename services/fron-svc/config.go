@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"go.local/pkg/env"
+)
+
+// defaultDeviceTag is the device_id tag used when a single inverter is
+// configured with INVERTER_URL rather than INVERTERS.
+const defaultDeviceTag = "fronius"
+
+// inverterConfig describes one inverter to poll.
+type inverterConfig struct {
+	Name      string  // device_id tag written on every point
+	URL       string  // datalogger base URL
+	DeviceID  int     // Fronius DeviceId on that datalogger
+	CapacityW float64 // rated output, used for utilisation
+}
+
+// loadInverters reads the inverter list from INVERTERS, a semicolon-separated
+// list of name,url,device_id,capacity_w entries, e.g.
+//
+//	roof,http://192.168.1.100,1,8200;garage,http://192.168.1.101,1,5000
+//
+// If INVERTERS is unset it falls back to a single inverter built from
+// INVERTER_URL and INVERTER_CAPACITY_W with DeviceId 1.
+func loadInverters() ([]inverterConfig, error) {
+	v := os.Getenv("INVERTERS")
+	if v == "" {
+		inverterURL := env.Required("INVERTER_URL")
+		capacityW, err := strconv.ParseFloat(env.Required("INVERTER_CAPACITY_W"), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INVERTER_CAPACITY_W: %w", err)
+		}
+		return []inverterConfig{{
+			Name:      defaultDeviceTag,
+			URL:       inverterURL,
+			DeviceID:  1,
+			CapacityW: capacityW,
+		}}, nil
+	}
+
+	entries, err := splitList(v, "name,url,device_id,capacity_w")
+	if err != nil {
+		return nil, fmt.Errorf("invalid INVERTERS: %w", err)
+	}
+
+	var inverters []inverterConfig
+	seen := make(map[string]bool)
+	for i, fields := range entries {
+		inv, err := parseInverter(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INVERTERS entry %d: %w", i+1, err)
+		}
+		if seen[inv.Name] {
+			return nil, fmt.Errorf("invalid INVERTERS entry %d: duplicate name %q", i+1, inv.Name)
+		}
+		seen[inv.Name] = true
+		inverters = append(inverters, inv)
+	}
+	return inverters, nil
+}
+
+// loadInverterCapacities reads INVERTER_CAPACITIES_W, an optional
+// semicolon-separated list of device_id,capacity_w entries rating inverters
+// found by discovery, e.g.
+//
+//	2,5000;3,3000
+//
+// Discovered inverters without an entry are rated at INVERTER_CAPACITY_W.
+func loadInverterCapacities() (map[int]float64, error) {
+	v := os.Getenv("INVERTER_CAPACITIES_W")
+	if v == "" {
+		return nil, nil
+	}
+
+	entries, err := splitList(v, "device_id,capacity_w")
+	if err != nil {
+		return nil, fmt.Errorf("invalid INVERTER_CAPACITIES_W: %w", err)
+	}
+
+	capacities := make(map[int]float64)
+	for i, fields := range entries {
+		deviceID, err := strconv.Atoi(fields[0])
+		if err != nil || deviceID < 0 {
+			return nil, fmt.Errorf("invalid INVERTER_CAPACITIES_W entry %d: device_id must be a non-negative integer, got %q", i+1, fields[0])
+		}
+		if _, ok := capacities[deviceID]; ok {
+			return nil, fmt.Errorf("invalid INVERTER_CAPACITIES_W entry %d: duplicate device_id %d", i+1, deviceID)
+		}
+		capacityW, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || capacityW <= 0 {
+			return nil, fmt.Errorf("invalid INVERTER_CAPACITIES_W entry %d: capacity_w must be a positive number, got %q", i+1, fields[1])
+		}
+		capacities[deviceID] = capacityW
+	}
+	return capacities, nil
+}
+
+func parseInverter(fields []string) (inverterConfig, error) {
+	name, baseURL, deviceID, err := parseDevice(fields)
+	if err != nil {
+		return inverterConfig{}, err
+	}
+	capacityW, err := strconv.ParseFloat(fields[3], 64)
+	if err != nil || capacityW <= 0 {
+		return inverterConfig{}, fmt.Errorf("capacity_w must be a positive number, got %q", fields[3])
+	}
+	return inverterConfig{
+		Name:      name,
+		URL:       baseURL,
+		DeviceID:  deviceID,
+		CapacityW: capacityW,
+	}, nil
+}
+
+// deviceConfig describes one meter or storage device to poll.
+type deviceConfig struct {
+	Name     string // device_id tag written on every point
+	URL      string // datalogger base URL
+	DeviceID int    // Fronius DeviceId, usually 0 for meters and storage
+}
+
+// loadDevices reads an optional device list from the named variable, a
+// semicolon-separated list of name,url,device_id entries, e.g.
+//
+//	grid,http://192.168.1.100,0
+func loadDevices(key string) ([]deviceConfig, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return nil, nil
+	}
+
+	entries, err := splitList(v, "name,url,device_id")
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	var devices []deviceConfig
+	seen := make(map[string]bool)
+	for i, fields := range entries {
+		name, baseURL, deviceID, err := parseDevice(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %d: %w", key, i+1, err)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("invalid %s entry %d: duplicate name %q", key, i+1, name)
+		}
+		seen[name] = true
+		devices = append(devices, deviceConfig{Name: name, URL: baseURL, DeviceID: deviceID})
+	}
+	return devices, nil
+}
+
+// splitList splits a semicolon-separated list of comma-separated entries into
+// trimmed fields. Every entry must have as many fields as usage names.
+func splitList(v, usage string) ([][]string, error) {
+	n := len(strings.Split(usage, ","))
+
+	var entries [][]string
+	for i, entry := range strings.Split(v, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		fields := strings.Split(entry, ",")
+		if len(fields) != n {
+			return nil, fmt.Errorf("entry %d: want %s, got %q", i+1, usage, entry)
+		}
+		for j := range fields {
+			fields[j] = strings.TrimSpace(fields[j])
+		}
+		entries = append(entries, fields)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries")
+	}
+	return entries, nil
+}
+
+// parseDevice parses the leading name,url,device_id fields shared by every
+// device entry.
+func parseDevice(fields []string) (name, baseURL string, deviceID int, err error) {
+	name = fields[0]
+	if name == "" {
+		return "", "", 0, fmt.Errorf("name is empty")
+	}
+	if _, err := url.ParseRequestURI(fields[1]); err != nil {
+		return "", "", 0, fmt.Errorf("url: %w", err)
+	}
+	deviceID, err = strconv.Atoi(fields[2])
+	if err != nil {
+		return "", "", 0, fmt.Errorf("device_id: %w", err)
+	}
+	return name, strings.TrimSuffix(fields[1], "/"), deviceID, nil
+}
+
+// dataloggerTag returns the host of a datalogger URL, used to tag site-level points.
+func dataloggerTag(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,512 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.local/pkg/env"
+)
+
+const (
+	// maintainInterval is how often finished days are rolled up and expired
+	// files removed.
+	maintainInterval = time.Hour
+
+	defaultCSVDir           = "data"
+	defaultCSVRetentionDays = 30
+)
+
+// csvTags are the tag columns of the data model. They are kept as text when
+// a day is rolled up, even when they look numeric.
+var csvTags = map[string]bool{"device_id": true, "datalogger": true, "location": true, "from": true, "to": true}
+
+// csvConfig holds the csv sink settings.
+type csvConfig struct {
+	Dir           string
+	RetentionDays int
+}
+
+func loadCSVConfig() csvConfig {
+	dir := os.Getenv("CSV_DIR")
+	if dir == "" {
+		dir = defaultCSVDir
+	}
+	return csvConfig{Dir: dir, RetentionDays: env.Int("CSV_RETENTION_DAYS", defaultCSVRetentionDays)}
+}
+
+// csvSink records readings to rolling daily CSV files, one directory per
+// measurement and device:
+//
+//	<dir>/inverter/roof/2026-06-01.csv         every reading that day
+//	<dir>/inverter/roof/hourly/2026-06-01.csv  hourly rollups of that day
+//	<dir>/inverter/roof/daily.csv              one rollup row per day
+//
+// Columns are time, then tags, then fields. A field that first appears part
+// way through a day widens that day's file. Days follow TZ.
+type csvSink struct {
+	cfg    csvConfig
+	health *health
+
+	mu    sync.Mutex
+	files map[string]*csvFile // open day files, by series directory
+}
+
+// csvFile is the open day file of one series.
+type csvFile struct {
+	path    string
+	day     string
+	f       *os.File
+	w       *csv.Writer
+	columns []string
+}
+
+func newCSVSink(cfg csvConfig, h *health) (*csvSink, error) {
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	log.Printf("  CSV_DIR             = %s (raw files kept %d days)", cfg.Dir, cfg.RetentionDays)
+	h.setSink("csv", nil, stateDown)
+	return &csvSink{cfg: cfg, health: h, files: make(map[string]*csvFile)}, nil
+}
+
+func (s *csvSink) Name() string { return "csv" }
+
+func (s *csvSink) Write(ctx context.Context, readings ...Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var errs []error
+	written := make(map[*csvFile]bool)
+	for _, r := range readings {
+		cf, err := s.file(r)
+		if err == nil {
+			err = cf.write(r)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		written[cf] = true
+	}
+	for cf := range written {
+		cf.w.Flush()
+		if err := cf.w.Error(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.Join(errs...)
+	s.health.setSink(s.Name(), err, stateDown)
+	return err
+}
+
+func (s *csvSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for series, cf := range s.files {
+		errs = append(errs, cf.close())
+		delete(s.files, series)
+	}
+	return errors.Join(errs...)
+}
+
+// run rolls up finished days and removes expired day files, at startup and
+// then every maintainInterval.
+func (s *csvSink) run(ctx context.Context) {
+	ticker := time.NewTicker(maintainInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.maintain(time.Now()); err != nil {
+			log.Printf("CSV maintenance failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// file returns the open day file for r's series and day, switching files when
+// the day changes. s.mu must be held.
+func (s *csvSink) file(r Reading) (*csvFile, error) {
+	_, name := readingDevice(r)
+	series := filepath.Join(s.cfg.Dir, fileSegment(r.Measurement), fileSegment(name))
+	day := r.Time.In(time.Local).Format(time.DateOnly)
+
+	if cf, ok := s.files[series]; ok {
+		if cf.day == day {
+			return cf, nil
+		}
+		delete(s.files, series)
+		if err := cf.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.MkdirAll(series, 0o755); err != nil {
+		return nil, err
+	}
+	cf := &csvFile{path: filepath.Join(series, day+".csv"), day: day}
+	if err := cf.open(); err != nil {
+		return nil, err
+	}
+	s.files[series] = cf
+	return cf, nil
+}
+
+// open opens the file for appending, reading the header of an existing file.
+func (cf *csvFile) open() error {
+	if header, err := readHeader(cf.path); err == nil {
+		cf.columns = header
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	f, err := os.OpenFile(cf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	cf.f = f
+	cf.w = csv.NewWriter(f)
+	return nil
+}
+
+func (cf *csvFile) close() error {
+	cf.w.Flush()
+	return errors.Join(cf.w.Error(), cf.f.Close())
+}
+
+// write appends r, writing the header first for a new file and widening the
+// file if r has columns it lacks.
+func (cf *csvFile) write(r Reading) error {
+	row := readingRow(r)
+	if cf.columns == nil {
+		cf.columns = csvColumns(nil, row)
+		if err := cf.w.Write(cf.columns); err != nil {
+			return err
+		}
+	} else if columns := csvColumns(cf.columns, row); len(columns) > len(cf.columns) {
+		if err := cf.widen(columns); err != nil {
+			return err
+		}
+	}
+	return cf.w.Write(rowRecord(cf.columns, row))
+}
+
+// widen rewrites the file with extra columns, leaving them empty in the rows
+// already written.
+func (cf *csvFile) widen(columns []string) error {
+	if err := cf.close(); err != nil {
+		return err
+	}
+	_, rows, err := readCSV(cf.path)
+	if err != nil {
+		return err
+	}
+	if err := writeCSV(cf.path, columns, rows); err != nil {
+		return err
+	}
+	return cf.open()
+}
+
+// maintain rolls up every finished day whose day file changed since its last
+// rollup, then removes day files older than the retention period. Rollups are
+// kept.
+func (s *csvSink) maintain(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	today := now.In(time.Local).Format(time.DateOnly)
+	expiry := now.In(time.Local).AddDate(0, 0, -s.cfg.RetentionDays).Format(time.DateOnly)
+
+	days, err := filepath.Glob(filepath.Join(s.cfg.Dir, "*", "*", "????-??-??.csv"))
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range days {
+		series, file := filepath.Split(path)
+		series = filepath.Clean(series)
+		day := strings.TrimSuffix(file, ".csv")
+		if _, err := time.Parse(time.DateOnly, day); err != nil || day >= today {
+			continue
+		}
+		if filepath.Base(filepath.Dir(series)) != "inverter_event" {
+			if err := rollupDay(series, day); err != nil {
+				errs = append(errs, fmt.Errorf("rolling up %s: %w", path, err))
+				continue
+			}
+		}
+		if day < expiry {
+			if cf, ok := s.files[series]; ok && cf.day == day {
+				cf.close()
+				delete(s.files, series)
+			}
+			if err := os.Remove(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rollupDay writes the hourly rollups of one day file and its row in
+// daily.csv, unless they are newer than the day file.
+func rollupDay(series, day string) error {
+	path := filepath.Join(series, day+".csv")
+	hourlyPath := filepath.Join(series, "hourly", day+".csv")
+	if fresh, err := newerThan(hourlyPath, path); err != nil || fresh {
+		return err
+	}
+
+	header, rows, err := readCSV(path)
+	if err != nil {
+		return err
+	}
+	measurement := filepath.Base(filepath.Dir(series))
+	var (
+		hours []*rollup
+		daily *rollup
+	)
+	for _, row := range rows {
+		r, err := rowReading(row)
+		if err != nil {
+			return err
+		}
+		// The hour repeated when clocks go back is two rows, like in the
+		// downsampled buckets.
+		t := r.Time.In(time.Local)
+		hour := startOfHour(t)
+		if daily == nil {
+			daily = newRollup(startOfDay(t))
+		}
+		if len(hours) == 0 || !hours[len(hours)-1].start.Equal(hour) {
+			hours = append(hours, newRollup(hour))
+		}
+		hours[len(hours)-1].add(r)
+		daily.add(r)
+	}
+	if daily == nil {
+		return nil
+	}
+
+	hourlyRows := make([]map[string]string, len(hours))
+	columns := header
+	for i, h := range hours {
+		hourlyRows[i] = readingRow(h.reading(measurement))
+		columns = csvColumns(columns, hourlyRows[i])
+	}
+	if err := os.MkdirAll(filepath.Dir(hourlyPath), 0o755); err != nil {
+		return err
+	}
+	if err := writeCSV(hourlyPath, columns, hourlyRows); err != nil {
+		return err
+	}
+	return updateDaily(filepath.Join(series, "daily.csv"), header, readingRow(daily.reading(measurement)))
+}
+
+// updateDaily inserts or replaces the row for one day in daily.csv. A new
+// file takes the day file's column order.
+func updateDaily(path string, header []string, row map[string]string) error {
+	columns, rows, err := readCSV(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if columns == nil {
+		columns = header
+	}
+	day := row["time"]
+	rows = slices.DeleteFunc(rows, func(r map[string]string) bool { return r["time"] == day })
+	rows = append(rows, row)
+	slices.SortFunc(rows, func(a, b map[string]string) int { return strings.Compare(a["time"], b["time"]) })
+	return writeCSV(path, csvColumns(columns, row), rows)
+}
+
+// newerThan reports whether a exists and was modified after b.
+func newerThan(a, b string) (bool, error) {
+	ai, err := os.Stat(a)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return ai.ModTime().After(bi.ModTime()), nil
+}
+
+// readingRow renders a reading as column values.
+func readingRow(r Reading) map[string]string {
+	row := make(map[string]string, len(r.Tags)+len(r.Fields)+1)
+	row["time"] = r.Time.In(time.Local).Format(time.RFC3339Nano)
+	maps.Copy(row, r.Tags)
+	for k, v := range r.Fields {
+		row[k] = formatValue(v)
+	}
+	return row
+}
+
+// rowReading parses a row back into a reading. Tag columns become tags;
+// other columns become numbers where they parse as one, else strings.
+func rowReading(row map[string]string) (Reading, error) {
+	t, err := time.Parse(time.RFC3339Nano, row["time"])
+	if err != nil {
+		return Reading{}, err
+	}
+	r := Reading{Tags: make(map[string]string), Fields: make(map[string]interface{}), Time: t}
+	for k, v := range row {
+		switch {
+		case k == "time" || v == "":
+		case csvTags[k]:
+			r.Tags[k] = v
+		default:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				r.Fields[k] = f
+			} else {
+				r.Fields[k] = v
+			}
+		}
+	}
+	return r, nil
+}
+
+// csvColumns returns columns followed by any of row's keys it lacks: time
+// first, then tags, then fields, each sorted.
+func csvColumns(columns []string, row map[string]string) []string {
+	out := slices.Clone(columns)
+	if !slices.Contains(out, "time") {
+		out = append([]string{"time"}, out...)
+	}
+	var tags, fields []string
+	for _, k := range slices.Sorted(maps.Keys(row)) {
+		switch {
+		case slices.Contains(out, k):
+		case csvTags[k]:
+			tags = append(tags, k)
+		default:
+			fields = append(fields, k)
+		}
+	}
+	return append(append(out, tags...), fields...)
+}
+
+func rowRecord(columns []string, row map[string]string) []string {
+	record := make([]string, len(columns))
+	for i, c := range columns {
+		record[i] = row[c]
+	}
+	return record
+}
+
+// readHeader reads the header row of a CSV file, or nil for an empty file.
+// Rows are not read.
+func readHeader(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, err := csv.NewReader(f).Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return header, err
+}
+
+// readCSV reads a CSV file with a header row.
+func readCSV(path string) ([]string, []map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var rows []map[string]string
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return header, rows, nil
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		row := make(map[string]string, len(header))
+		for i, v := range record {
+			if i < len(header) {
+				row[header[i]] = v
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// writeCSV replaces path atomically with a header and rows.
+func writeCSV(path string, columns []string, rows []map[string]string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write(columns)
+	for _, row := range rows {
+		w.Write(rowRecord(columns, row))
+	}
+	w.Flush()
+	if err := errors.Join(w.Error(), f.Close()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+var fileUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileSegment makes s safe as a single path element: it cannot contain a
+// separator, and cannot be empty, "." or "..".
+func fileSegment(s string) string {
+	s = fileUnsafe.ReplaceAllString(s, "_")
+	if strings.Trim(s, ".") == "" {
+		return "_" + s
+	}
+	return s
+}
+
+// formatValue renders a field value for a CSV cell.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRollupDayRepeatedHour(t *testing.T) {
+	local := time.Local
+	time.Local = mustLoad(t, "Europe/London")
+	t.Cleanup(func() { time.Local = local })
+
+	// Clocks go back at 02:00 BST on 25 October 2026, so 01:30 happens twice.
+	series := filepath.Join(t.TempDir(), "inverter", "roof")
+	if err := os.MkdirAll(series, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var rows []map[string]string
+	for _, utc := range []string{"2026-10-25T00:30:00Z", "2026-10-25T01:30:00Z"} {
+		at, _ := time.Parse(time.RFC3339, utc)
+		rows = append(rows, map[string]string{"time": at.In(time.Local).Format(time.RFC3339Nano), "pac": "1000"})
+	}
+	if err := writeCSV(filepath.Join(series, "2026-10-25.csv"), []string{"time", "pac"}, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rollupDay(series, "2026-10-25"); err != nil {
+		t.Fatal(err)
+	}
+	_, hourly, err := readCSV(filepath.Join(series, "hourly", "2026-10-25.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"2026-10-25T01:00:00+01:00", "2026-10-25T01:00:00Z"}
+	if len(hourly) != len(want) {
+		t.Fatalf("got %d hourly rows, want %d: the repeated hour was merged", len(hourly), len(want))
+	}
+	for i, w := range want {
+		if hourly[i]["time"] != w {
+			t.Errorf("hour %d stamped %s, want %s", i+1, hourly[i]["time"], w)
+		}
+	}
+}
+
+func TestFileSegment(t *testing.T) {
+	for in, want := range map[string]string{
+		"roof":       "roof",
+		"roof.south": "roof.south",
+		"../etc":     ".._etc",
+		"a/b\\c":     "a_b_c",
+		"":           "_",
+		".":          "_.",
+		"..":         "_..",
+		"Garage Süd": "Garage_S_d",
+	} {
+		if got := fileSegment(in); got != want {
+			t.Errorf("fileSegment(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCSVFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2026-06-01.csv")
+	rows := []map[string]string{{"time": "2026-06-01T12:00:00Z", "pac": "1000"}}
+	if err := writeCSV(path, []string{"time", "pac"}, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	cf := &csvFile{path: path}
+	if err := cf.open(); err != nil {
+		t.Fatal(err)
+	}
+	if len(cf.columns) != 2 || cf.columns[0] != "time" || cf.columns[1] != "pac" {
+		t.Errorf("columns = %q, want the existing header", cf.columns)
+	}
+	r := Reading{Fields: map[string]interface{}{"pac": 2000.0}, Time: time.Date(2026, 6, 1, 12, 0, 5, 0, time.UTC)}
+	if err := cf.write(r); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.close(); err != nil {
+		t.Fatal(err)
+	}
+	header, got, err := readCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(header) != 2 || len(got) != 2 || got[1]["pac"] != "2000" {
+		t.Errorf("file = %q %v, want a second row appended under the same header", header, got)
+	}
+}
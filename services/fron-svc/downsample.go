@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+)
+
+const (
+	// downsampleInterval is how often finished periods are looked for.
+	downsampleInterval = 5 * time.Minute
+
+	// downsampleDelay is how long after a period ends it is aggregated, so
+	// points from the final poll and short spool replays are included.
+	downsampleDelay = 5 * time.Minute
+)
+
+// downsamplePeriod is one aggregation level.
+type downsamplePeriod struct {
+	name   string // checkpoint key suffix and log label
+	bucket string
+	api    api.WriteAPIBlocking
+	start  func(time.Time) time.Time // start of the period containing t
+	next   func(time.Time) time.Time // start of the following period
+}
+
+// downsampler aggregates the raw bucket into hourly and daily buckets, replacing
+// the Flux tasks once documented in the README. It has InfluxDB aggregate the
+// raw bucket after each period ends rather than aggregating in memory, so
+// restarts and spool replays are reflected. Progress is kept in the checkpoints file, and each
+// period is aggregated once; the service starts it only after the startup
+// catch-up, and "fron-svc backfill" calls reaggregate for what it wrote.
+type downsampler struct {
+	client      influxdb2.Client
+	query       api.QueryAPI
+	raw         string
+	periods     []downsamplePeriod
+	checkpoints *checkpoints
+	health      *health
+	maxAge      time.Duration
+}
+
+// newDownsampler returns a downsampler for the buckets configured in cfg, or
+// nil if neither INFLUX_HOURLY_BUCKET nor INFLUX_DAILY_BUCKET is set. On first
+// start, and after a long outage, it goes back at most maxDays.
+func newDownsampler(cfg influxConfig, cps *checkpoints, h *health, maxDays int) *downsampler {
+	if cfg.HourlyBucket == "" && cfg.DailyBucket == "" {
+		return nil
+	}
+	client := influxdb2.NewClient(cfg.URL, cfg.Token)
+	d := &downsampler{
+		client:      client,
+		query:       client.QueryAPI(cfg.Org),
+		raw:         cfg.Bucket,
+		checkpoints: cps,
+		health:      h,
+		maxAge:      time.Duration(maxDays) * 24 * time.Hour,
+	}
+	if cfg.HourlyBucket != "" {
+		log.Printf("  INFLUX_HOURLY_BUCKET = %s", cfg.HourlyBucket)
+		d.periods = append(d.periods, downsamplePeriod{
+			name:   "hourly",
+			bucket: cfg.HourlyBucket,
+			api:    client.WriteAPIBlocking(cfg.Org, cfg.HourlyBucket),
+			start:  startOfHour,
+			next:   nextHour,
+		})
+	}
+	if cfg.DailyBucket != "" {
+		log.Printf("  INFLUX_DAILY_BUCKET = %s", cfg.DailyBucket)
+		d.periods = append(d.periods, downsamplePeriod{
+			name:   "daily",
+			bucket: cfg.DailyBucket,
+			api:    client.WriteAPIBlocking(cfg.Org, cfg.DailyBucket),
+			start:  startOfDay,
+			next:   nextDay,
+		})
+	}
+	return d
+}
+
+// run aggregates every finished period until ctx is cancelled.
+func (d *downsampler) run(ctx context.Context) {
+	defer d.client.Close()
+	ticker := time.NewTicker(downsampleInterval)
+	defer ticker.Stop()
+	for {
+		if d.health.sinkState("influxdb").SpooledBytes == 0 {
+			for _, p := range d.periods {
+				if err := d.catchUp(ctx, p, time.Now()); err != nil && ctx.Err() == nil {
+					log.Printf("Downsampling %s failed: %v", p.name, err)
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// catchUp aggregates each period of p that ended at least downsampleDelay
+// before now and has not been aggregated yet.
+func (d *downsampler) catchUp(ctx context.Context, p downsamplePeriod, now time.Time) error {
+	key := "downsample/" + p.name
+	start := p.start(d.checkpoints.get(key).Local())
+	if oldest := p.start(now.Add(-d.maxAge)); start.Before(oldest) {
+		start = oldest
+	}
+	for {
+		end := p.next(start)
+		if end.Add(downsampleDelay).After(now) {
+			return nil
+		}
+		n, err := d.aggregate(ctx, p, start, end)
+		if err != nil {
+			return fmt.Errorf("%s: %w", start.Format(time.RFC3339), err)
+		}
+		if n > 0 {
+			log.Printf("Downsampled %s to %s: %d series", start.Format(time.RFC3339), p.bucket, n)
+		}
+		d.checkpoints.set(key, end)
+		start = end
+	}
+}
+
+// reaggregate aggregates every finished period overlapping [from, to), whether
+// or not it was aggregated before, so readings backfilled into it are
+// included. It leaves the checkpoints alone.
+func (d *downsampler) reaggregate(ctx context.Context, from, to, now time.Time) error {
+	for _, p := range d.periods {
+		for start := p.start(from); start.Before(to); start = p.next(start) {
+			end := p.next(start)
+			if end.Add(downsampleDelay).After(now) {
+				break
+			}
+			n, err := d.aggregate(ctx, p, start, end)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", p.name, start.Format(time.RFC3339), err)
+			}
+			if n > 0 {
+				log.Printf("Downsampled %s to %s: %d series", start.Format(time.RFC3339), p.bucket, n)
+			}
+		}
+	}
+	return nil
+}
+
+// aggregate rolls up the raw readings in [start, end) and writes one point per
+// series, stamped with start, to p's bucket. It returns the number of series.
+// InfluxDB does the aggregation: only one row per series and field comes
+// back, however many readings the period holds.
+func (d *downsampler) aggregate(ctx context.Context, p downsamplePeriod, start, end time.Time) (int, error) {
+	result, err := d.query.Query(ctx, rollupQuery(d.raw, start, end))
+	if err != nil {
+		return 0, err
+	}
+	defer result.Close()
+
+	rollups := make(map[string]*Reading)
+	for result.Next() {
+		rec := result.Record()
+		tags := make(map[string]string)
+		for k, v := range rec.Values() {
+			if s, ok := v.(string); ok && !strings.HasPrefix(k, "_") && k != "result" && k != "table" {
+				tags[k] = s
+			}
+		}
+		key := seriesKey(rec.Measurement(), tags)
+		r, ok := rollups[key]
+		if !ok {
+			r = &Reading{Measurement: rec.Measurement(), Tags: tags, Fields: make(map[string]interface{}), Time: start}
+			rollups[key] = r
+		}
+		switch field := rec.Field(); rec.Result() {
+		case "samples":
+			r.Fields["samples"] = rec.Value()
+		case "min":
+			r.Fields[field+"_min"] = rec.Value()
+		case "max":
+			r.Fields[field+"_max"] = rec.Value()
+			r.Fields[field+"_peak_time"] = rec.Time().UTC().Format(time.RFC3339)
+		default: // mean, sum and last
+			r.Fields[field] = rec.Value()
+		}
+	}
+	if err := result.Err(); err != nil {
+		return 0, err
+	}
+	if len(rollups) == 0 {
+		return 0, nil
+	}
+
+	points := make([]*write.Point, 0, len(rollups))
+	for _, key := range slices.Sorted(maps.Keys(rollups)) {
+		r := rollups[key]
+		points = append(points, influxdb2.NewPoint(r.Measurement, r.Tags, r.Fields, r.Time))
+	}
+	if err := p.api.WritePoint(ctx, points...); err != nil {
+		return 0, err
+	}
+	return len(points), nil
+}
+
+// lastFieldPattern matches the numeric fields whose rollup is their last
+// value, as lastField does.
+const lastFieldPattern = `energy|^e_|^day_|_code$`
+
+// rollupQuery returns the Flux query that aggregates the raw bucket over
+// [start, end) as rollup does: the mean of instantaneous fields, the sum of
+// sumFields, the last value of energy counters, codes and strings, the
+// extremes of peakFields, and the number of samples. Each kind of aggregate is
+// its own result.
+func rollupQuery(bucket string, start, end time.Time) string {
+	return fmt.Sprintf(`import "types"
+
+data = from(bucket: %q)
+  |> range(start: %s, stop: %s)
+  |> filter(fn: (r) => r._measurement != "inverter_event")
+keepLast = (r) => types.isType(v: r._value, type: "string") or types.isType(v: r._value, type: "bool") or r._field =~ /%s/
+summed = %s
+peaks = %s
+
+data |> filter(fn: (r) => keepLast(r: r)) |> last() |> yield(name: "last")
+data |> filter(fn: (r) => not keepLast(r: r) and contains(value: r._field, set: summed)) |> sum() |> yield(name: "sum")
+data |> filter(fn: (r) => not keepLast(r: r) and not contains(value: r._field, set: summed)) |> mean() |> yield(name: "mean")
+data |> filter(fn: (r) => contains(value: r._field, set: peaks)) |> min() |> yield(name: "min")
+data |> filter(fn: (r) => contains(value: r._field, set: peaks)) |> max() |> yield(name: "max")
+data |> count() |> group(columns: ["_field", "_value"], mode: "except") |> max() |> yield(name: "samples")`,
+		bucket, start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339),
+		lastFieldPattern, fluxStrings(sumFields), fluxStrings(peakFields))
+}
+
+// fluxStrings returns the keys of set as a Flux array literal.
+func fluxStrings(set map[string]bool) string {
+	var quoted []string
+	for _, k := range slices.Sorted(maps.Keys(set)) {
+		quoted = append(quoted, strconv.Quote(k))
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// startOfHour returns the start of the local hour containing t. It subtracts
+// rather than calling time.Date, which is ambiguous in the hour repeated when
+// clocks go back.
+func startOfHour(t time.Time) time.Time {
+	return t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+}
+
+// nextHour returns the start of the hour after the one containing t. Hours are
+// stepped in absolute time, so the hours skipped and repeated at daylight
+// saving changes are neither looped over nor merged.
+func nextHour(t time.Time) time.Time {
+	return startOfHour(t).Add(time.Hour)
+}
+
+// nextDay returns the start of the local day after the one containing t. Half
+// way into a 23- or 25-hour day is still that day, so this is safe where
+// adding 24 hours is not.
+func nextDay(t time.Time) time.Time {
+	return startOfDay(startOfDay(t).Add(36 * time.Hour))
+}
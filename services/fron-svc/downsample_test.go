@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+// steps walks next from start until end, failing if it does not advance.
+func steps(t *testing.T, next func(time.Time) time.Time, start, end time.Time) []time.Time {
+	t.Helper()
+	var out []time.Time
+	for s := start; s.Before(end); {
+		n := next(s)
+		if !n.After(s) {
+			t.Fatalf("next(%s) = %s, does not advance", s, n)
+		}
+		out = append(out, s)
+		s = n
+		if len(out) > 1000 {
+			t.Fatal("too many steps")
+		}
+	}
+	return out
+}
+
+func TestNextHourDST(t *testing.T) {
+	for _, tc := range []struct {
+		zone  string
+		day   time.Time // local midnight of a day with a change
+		hours int
+	}{
+		{"America/New_York", time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC), 23},
+		{"America/New_York", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), 25},
+		{"Europe/Vienna", time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC), 23},
+		{"Europe/Vienna", time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), 25},
+		{"Asia/Kathmandu", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 24},
+	} {
+		loc := mustLoad(t, tc.zone)
+		start := time.Date(tc.day.Year(), tc.day.Month(), tc.day.Day(), 0, 0, 0, 0, loc)
+		hours := steps(t, nextHour, start, nextDay(start))
+		if len(hours) != tc.hours {
+			t.Errorf("%s %s: %d hours, want %d", tc.zone, tc.day.Format(time.DateOnly), len(hours), tc.hours)
+		}
+		for _, h := range hours {
+			if h.Minute() != 0 || h.Second() != 0 {
+				t.Errorf("%s: hour starts at %s", tc.zone, h)
+			}
+			if d := nextHour(h).Sub(h); d != time.Hour {
+				t.Errorf("%s: hour at %s lasts %s", tc.zone, h, d)
+			}
+		}
+	}
+}
+
+func TestStartOfHourRepeated(t *testing.T) {
+	loc := mustLoad(t, "America/New_York")
+	// 01:30 EST, in the second 1 o'clock of 1 November 2026.
+	second := time.Date(2026, 11, 1, 6, 30, 0, 0, time.UTC).In(loc)
+	if got, want := startOfHour(second), time.Date(2026, 11, 1, 6, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("startOfHour(%s) = %s, want %s", second, got, want.In(loc))
+	}
+}
+
+func TestNextDayDST(t *testing.T) {
+	for _, tc := range []struct {
+		zone string
+		day  time.Time
+		want time.Duration
+	}{
+		{"Europe/Vienna", time.Date(2026, 3, 29, 0, 0, 0, 0, time.UTC), 23 * time.Hour},
+		{"Europe/Vienna", time.Date(2026, 10, 25, 0, 0, 0, 0, time.UTC), 25 * time.Hour},
+		{"America/New_York", time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC), 25 * time.Hour},
+		{"Europe/Vienna", time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC), 24 * time.Hour},
+	} {
+		loc := mustLoad(t, tc.zone)
+		start := time.Date(tc.day.Year(), tc.day.Month(), tc.day.Day(), 0, 0, 0, 0, loc)
+		next := nextDay(start)
+		if d := next.Sub(start); d != tc.want {
+			t.Errorf("%s %s: day lasts %s, want %s", tc.zone, tc.day.Format(time.DateOnly), d, tc.want)
+		}
+		if next.Hour() != 0 || next.Day() == start.Day() {
+			t.Errorf("%s: nextDay(%s) = %s, want the next midnight", tc.zone, start, next)
+		}
+	}
+
+	// In Santiago clocks skip from midnight to 01:00, so that day starts at
+	// 01:00; a year of days must still advance one calendar day at a time.
+	loc := mustLoad(t, "America/Santiago")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, loc)
+	days := steps(t, nextDay, start, time.Date(2027, 1, 1, 0, 0, 0, 0, loc))
+	if len(days) != 365 {
+		t.Errorf("Santiago: %d days in 2026, want 365", len(days))
+	}
+	skipped := time.Date(2026, 9, 6, 12, 0, 0, 0, loc)
+	if got := startOfDay(skipped); got.Day() != 6 || got.Hour() != 1 {
+		t.Errorf("startOfDay(%s) = %s, want 01:00 that day", skipped, got)
+	}
+}
+
+func TestLastFieldPattern(t *testing.T) {
+	re := regexp.MustCompile(lastFieldPattern)
+	for _, field := range []string{
+		"day_energy", "total_energy", "energy_import", "e_day", "e_total",
+		"day_earnings", "status_code", "error_code",
+		"pac", "pac_kw", "udc_2", "power", "earnings", "soc", "p_grid", "utilisation",
+	} {
+		if got, want := re.MatchString(field), lastField(field); got != want {
+			t.Errorf("%s: pattern matches = %v, lastField = %v", field, got, want)
+		}
+	}
+}
+
+// aggregateCSV is what InfluxDB returns for rollupQuery over one hour with
+// one inverter: a table per result, in annotated CSV.
+const aggregateCSV = `#group,false,false,true,true,true,true,true,false
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,string,string,string,double
+#default,mean,,,,,,,
+,result,table,_start,_stop,_field,_measurement,device_id,_value
+,,0,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,pac,inverter,roof,1500
+
+#group,false,false,true,true,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#default,last,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,device_id
+,,1,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,2026-06-15T10:55:00Z,7000,day_energy,inverter,roof
+
+#group,false,false,true,true,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,string,string,string,string
+#default,last,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,device_id
+,,2,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,2026-06-15T10:55:00Z,running,state,inverter,roof
+
+#group,false,false,true,true,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#default,max,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,device_id
+,,3,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,2026-06-15T10:35:00Z,3000,pac,inverter,roof
+
+#group,false,false,true,true,false,false,true,true,true
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,dateTime:RFC3339,double,string,string,string
+#default,min,,,,,,,,
+,result,table,_start,_stop,_time,_value,_field,_measurement,device_id
+,,4,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,2026-06-15T10:00:00Z,200,pac,inverter,roof
+
+#group,false,false,true,true,false,true,true,false
+#datatype,string,long,dateTime:RFC3339,dateTime:RFC3339,string,string,string,long
+#default,samples,,,,,,,
+,result,table,_start,_stop,_field,_measurement,device_id,_value
+,,5,2026-06-15T10:00:00Z,2026-06-15T11:00:00Z,pac,inverter,roof,12
+
+`
+
+func TestDownsampleAggregate(t *testing.T) {
+	var query, written string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/api/v2/query":
+			var q struct{ Query string }
+			json.Unmarshal(body, &q)
+			query = q.Query
+			w.Header().Set("Content-Type", "text/csv")
+			io.WriteString(w, aggregateCSV)
+		case "/api/v2/write":
+			written = string(body)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	client := influxdb2.NewClient(srv.URL, "token")
+	defer client.Close()
+
+	d := &downsampler{client: client, query: client.QueryAPI("home"), raw: "fronius"}
+	p := downsamplePeriod{name: "hourly", bucket: "fronius_hourly", api: client.WriteAPIBlocking("home", "fronius_hourly")}
+	start := time.Date(2026, 6, 15, 10, 0, 0, 0, time.UTC)
+	n, err := d.aggregate(context.Background(), p, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("wrote %d series, want 1", n)
+	}
+
+	for _, want := range []string{
+		`from(bucket: "fronius")`,
+		"range(start: 2026-06-15T10:00:00Z, stop: 2026-06-15T11:00:00Z)",
+		`summed = ["cost", "earnings", "savings"]`,
+		`peaks = ["pac"]`,
+		"mean()", "sum()", "last()", "min()", "max()", "count()",
+	} {
+		if !strings.Contains(query, want) {
+			t.Errorf("query does not contain %s:\n%s", want, query)
+		}
+	}
+
+	line := strings.TrimSpace(written)
+	if !strings.HasPrefix(line, "inverter,device_id=roof ") || !strings.HasSuffix(line, " 1781517600000000000") {
+		t.Fatalf("wrote %q, want one roof inverter point stamped with the hour", line)
+	}
+	for _, want := range []string{
+		"pac=1500", "pac_max=3000", "pac_min=200", `pac_peak_time="2026-06-15T10:35:00Z"`,
+		"day_energy=7000", `state="running"`, "samples=12i",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("point %q has no %s", line, want)
+		}
+	}
+}
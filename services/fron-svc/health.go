@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Component states reported on /healthz.
+const (
+	stateOK       = "ok"
+	stateDown     = "down"
+	stateSpooling = "spooling"
+)
+
+// componentHealth is the last known state of one device or sink.
+type componentHealth struct {
+	State string    `json:"state"`
+	Since time.Time `json:"since"`
+	Error string    `json:"error,omitempty"`
+}
+
+// sinkHealth is the last known state of one sink.
+type sinkHealth struct {
+	componentHealth
+	SpooledBytes int64 `json:"spooled_bytes,omitempty"`
+}
+
+// health tracks device and sink availability separately, so an InfluxDB
+// outage is not mistaken for an inverter outage and vice versa.
+type health struct {
+	mu      sync.Mutex
+	devices map[string]componentHealth
+	sinks   map[string]sinkHealth
+}
+
+func newHealth() *health {
+	return &health{
+		devices: make(map[string]componentHealth),
+		sinks:   make(map[string]sinkHealth),
+	}
+}
+
+// track wraps a poll function so each result updates the named device's state.
+func (h *health) track(name string, fn func(context.Context) error) func(context.Context) error {
+	return func(ctx context.Context) error {
+		err := fn(ctx)
+		if ctx.Err() == nil {
+			h.setDevice(name, err)
+		}
+		return err
+	}
+}
+
+// setDevice records whether the named device answered its last poll.
+func (h *health) setDevice(name string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.devices[name] = transition(h.devices[name], err, stateDown)
+}
+
+// setSink records whether the named sink's last write succeeded. Sinks report
+// their own health; failed is the state to report on error, e.g. stateSpooling
+// for a sink that holds readings back until its backend recovers.
+func (h *health) setSink(name string, err error, failed string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sh := h.sinks[name]
+	sh.componentHealth = transition(sh.componentHealth, err, failed)
+	h.sinks[name] = sh
+}
+
+// setSpooled records how many bytes the named sink has waiting to be written.
+func (h *health) setSpooled(name string, bytes int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sh := h.sinks[name]
+	sh.SpooledBytes = bytes
+	h.sinks[name] = sh
+}
+
+// sinkState returns the named sink's state.
+func (h *health) sinkState(name string) sinkHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sinks[name]
+}
+
+// deviceStates returns a copy of every device's state.
+func (h *health) deviceStates() map[string]componentHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return maps.Clone(h.devices)
+}
+
+func transition(c componentHealth, err error, failed string) componentHealth {
+	state, msg := stateOK, ""
+	if err != nil {
+		state, msg = failed, err.Error()
+	}
+	if c.State != state {
+		c.State = state
+		c.Since = time.Now()
+	}
+	c.Error = msg
+	return c
+}
+
+// serveHealth always answers 200 while the process is up: an unreachable
+// inverter is normal at night, and an InfluxDB outage is absorbed by the spool.
+// The body reports each component's state for dashboards and alerting.
+func (h *health) serveHealth(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	body := struct {
+		Devices map[string]componentHealth `json:"devices"`
+		Sinks   map[string]sinkHealth      `json:"sinks"`
+	}{Devices: maps.Clone(h.devices), Sinks: maps.Clone(h.sinks)}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
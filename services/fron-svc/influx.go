@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	influxhttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"go.local/pkg/env"
+	"go.local/services/fron-svc/internal/spool"
+)
+
+const (
+	// replayInterval is how often the spool is retried while InfluxDB is down.
+	replayInterval = 15 * time.Second
+
+	defaultSpoolMaxMB = 64
+)
+
+// influxConfig holds the InfluxDB connection settings.
+type influxConfig struct {
+	URL        string
+	Token      string
+	Org        string
+	Bucket     string
+	SpoolMaxMB int
+
+	// HourlyBucket and DailyBucket receive aggregates of Bucket when set.
+	HourlyBucket string
+	DailyBucket  string
+}
+
+// loadInfluxConfig reads the INFLUX_* variables. All but the downsampling
+// buckets are required when the influxdb sink is enabled.
+func loadInfluxConfig() influxConfig {
+	return influxConfig{
+		URL:        env.Required("INFLUX_URL"),
+		Token:      env.Required("INFLUX_TOKEN"),
+		Org:        env.Required("INFLUX_ORG"),
+		Bucket:     env.Required("INFLUX_BUCKET"),
+		SpoolMaxMB: env.Int("SPOOL_MAX_MB", defaultSpoolMaxMB),
+
+		HourlyBucket: os.Getenv("INFLUX_HOURLY_BUCKET"),
+		DailyBucket:  os.Getenv("INFLUX_DAILY_BUCKET"),
+	}
+}
+
+// influxSink writes readings to InfluxDB, spooling them to disk when InfluxDB
+// is unavailable and replaying them in order once it recovers.
+type influxSink struct {
+	client influxdb2.Client
+	api    api.WriteAPIBlocking
+	spool  *spool.Spool // nil when not spooling
+	health *health
+
+	wake chan struct{}
+}
+
+// newInfluxSink returns an influxdb sink. With spoolDir empty, failed writes
+// are returned as errors rather than spooled.
+func newInfluxSink(cfg influxConfig, spoolDir string, h *health) (*influxSink, error) {
+	s := &influxSink{health: h, wake: make(chan struct{}, 1)}
+	if spoolDir != "" {
+		sp, err := spool.Open(spoolDir, int64(cfg.SpoolMaxMB)<<20)
+		if err != nil {
+			return nil, err
+		}
+		s.spool = sp
+	}
+
+	log.Printf("  INFLUX_URL          = %s", cfg.URL)
+	log.Printf("  INFLUX_ORG          = %s", cfg.Org)
+	log.Printf("  INFLUX_BUCKET       = %s", cfg.Bucket)
+	if s.spool != nil {
+		log.Printf("  SPOOL_DIR           = %s (max %d MB, %d bytes queued)", spoolDir, cfg.SpoolMaxMB, s.spool.Size())
+	}
+
+	s.client = influxdb2.NewClient(cfg.URL, cfg.Token)
+	s.api = s.client.WriteAPIBlocking(cfg.Org, cfg.Bucket)
+	h.setSink(s.Name(), nil, stateSpooling)
+	h.setSpooled(s.Name(), s.spooled())
+	return s, nil
+}
+
+func (s *influxSink) Name() string { return "influxdb" }
+
+func (s *influxSink) Write(ctx context.Context, readings ...Reading) error {
+	points := make([]*write.Point, len(readings))
+	for i, r := range readings {
+		points[i] = influxdb2.NewPoint(r.Measurement, r.Tags, r.Fields, r.Time)
+	}
+
+	if s.spool == nil {
+		return s.api.WritePoint(ctx, points...)
+	}
+
+	// While anything is spooled, new points queue behind it to keep order.
+	if s.spool.Size() == 0 {
+		err := s.api.WritePoint(ctx, points...)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if rejected(err) {
+			log.Printf("InfluxDB rejected %d point(s), dropping: %v", len(points), err)
+			return nil
+		}
+		log.Printf("InfluxDB write failed, spooling to disk: %v", err)
+		s.health.setSink(s.Name(), err, stateSpooling)
+		s.notify()
+	}
+
+	lines := make([]string, len(points))
+	for i, pt := range points {
+		lines[i] = write.PointToLineProtocol(pt, time.Nanosecond)
+	}
+	dropped, err := s.spool.Append(lines)
+	if dropped > 0 {
+		log.Printf("Spool full, discarded %d bytes of the oldest points", dropped)
+	}
+	s.health.setSpooled(s.Name(), s.spool.Size())
+	return err
+}
+
+func (s *influxSink) Close() error {
+	s.client.Close()
+	if s.spool != nil {
+		return s.spool.Close()
+	}
+	return nil
+}
+
+// run replays the spool to InfluxDB until ctx is cancelled, oldest segment
+// first. A failed replay is retried every replayInterval.
+func (s *influxSink) run(ctx context.Context) {
+	if s.spool == nil {
+		return
+	}
+
+	timer := time.NewTimer(0) // replay anything left by a previous run
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-timer.C:
+		}
+		err := s.replay(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.health.setSink(s.Name(), err, stateSpooling)
+		s.health.setSpooled(s.Name(), s.spool.Size())
+		timer.Reset(replayInterval)
+	}
+}
+
+// notify makes run retry soon without blocking the caller.
+func (s *influxSink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// replay writes spooled segments until the spool is empty or a write fails.
+// A segment is removed only after it is written, so a crash mid-replay may
+// write some points twice; InfluxDB overwrites identical points, so that is
+// harmless.
+func (s *influxSink) replay(ctx context.Context) error {
+	var replayed int
+	for {
+		seg, err := s.spool.Oldest()
+		if err != nil {
+			return err
+		}
+		if seg == nil {
+			break
+		}
+		if len(seg.Lines) > 0 {
+			err := s.api.WriteRecord(ctx, seg.Lines...)
+			if rejected(err) {
+				log.Printf("InfluxDB rejected spooled segment %d, discarding %d line(s): %v", seg.Seq, len(seg.Lines), err)
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := s.spool.Remove(seg.Seq); err != nil {
+			return err
+		}
+		replayed += len(seg.Lines)
+	}
+	if replayed > 0 {
+		log.Printf("InfluxDB back online, replayed %d spooled point(s)", replayed)
+	}
+	return nil
+}
+
+func (s *influxSink) spooled() int64 {
+	if s.spool == nil {
+		return 0
+	}
+	return s.spool.Size()
+}
+
+// rejected reports whether InfluxDB refused the data itself, e.g. a field type
+// conflict, so retrying it would fail forever.
+func rejected(err error) bool {
+	var he *influxhttp.Error
+	if !errors.As(err, &he) {
+		return false
+	}
+	return he.StatusCode == 400 || he.StatusCode == 422
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	influxhttp "github.com/influxdata/influxdb-client-go/v2/api/http"
+	"github.com/influxdata/influxdb-client-go/v2/domain"
+	"go.local/pkg/env"
+)
+
+const (
+	rawRetention    = 30 * 24 * time.Hour
+	hourlyRetention = 365 * 24 * time.Hour
+
+	writeTokenDescription = "fron-svc write"
+	readTokenDescription  = "fron-svc read (Grafana)"
+)
+
+// bucketSpec is a bucket "fron-svc init" ensures exists. A zero retention
+// keeps data forever.
+type bucketSpec struct {
+	name      string
+	retention time.Duration
+}
+
+// initCommand implements "fron-svc init": using an admin token, it creates
+// the buckets and scoped tokens documented under InfluxDB setup, then prints
+// the matching configuration. Running it again changes nothing unless the
+// setup has drifted.
+func initCommand(args []string) {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	raw := fs.String("raw", "solar-raw", "bucket for raw readings, kept 30 days")
+	hourly := fs.String("hourly", "solar-hourly", "bucket for hourly aggregates, kept 365 days")
+	daily := fs.String("daily", "solar-daily", "bucket for daily aggregates, kept forever")
+	fs.Parse(args)
+
+	url := env.Required("INFLUX_URL")
+	org := env.Required("INFLUX_ORG")
+	adminToken := env.Required("INFLUX_ADMIN_TOKEN")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := influxdb2.NewClient(url, adminToken)
+	defer client.Close()
+
+	o, err := client.OrganizationsAPI().FindOrganizationByName(ctx, org)
+	if err != nil {
+		log.Fatalf("Failed to find organisation %q: %v", org, err)
+	}
+	orgID := *o.Id
+
+	specs := []bucketSpec{
+		{name: *raw, retention: rawRetention},
+		{name: *hourly, retention: hourlyRetention},
+		{name: *daily},
+	}
+	ids := make(map[string]string)
+	for _, spec := range specs {
+		id, err := ensureBucket(ctx, client.BucketsAPI(), orgID, spec)
+		if err != nil {
+			log.Fatalf("Failed to set up bucket %q: %v", spec.name, err)
+		}
+		ids[spec.name] = id
+	}
+
+	// The service writes raw readings, and reads them back to write the
+	// aggregates. Grafana only reads.
+	writePerms := []domain.Permission{
+		bucketPermission(domain.PermissionActionRead, orgID, ids[*raw]),
+		bucketPermission(domain.PermissionActionWrite, orgID, ids[*raw]),
+		bucketPermission(domain.PermissionActionWrite, orgID, ids[*hourly]),
+		bucketPermission(domain.PermissionActionWrite, orgID, ids[*daily]),
+	}
+	readPerms := []domain.Permission{
+		bucketPermission(domain.PermissionActionRead, orgID, ids[*raw]),
+		bucketPermission(domain.PermissionActionRead, orgID, ids[*hourly]),
+		bucketPermission(domain.PermissionActionRead, orgID, ids[*daily]),
+	}
+	writeToken, err := ensureToken(ctx, client.AuthorizationsAPI(), orgID, writeTokenDescription, writePerms)
+	if err != nil {
+		log.Fatalf("Failed to set up the write token: %v", err)
+	}
+	readToken, err := ensureToken(ctx, client.AuthorizationsAPI(), orgID, readTokenDescription, readPerms)
+	if err != nil {
+		log.Fatalf("Failed to set up the read token: %v", err)
+	}
+
+	fmt.Printf("INFLUX_URL=%s\n", url)
+	fmt.Printf("INFLUX_ORG=%s\n", org)
+	fmt.Printf("INFLUX_BUCKET=%s\n", *raw)
+	fmt.Printf("INFLUX_HOURLY_BUCKET=%s\n", *hourly)
+	fmt.Printf("INFLUX_DAILY_BUCKET=%s\n", *daily)
+	fmt.Printf("INFLUX_TOKEN=%s\n", writeToken)
+	fmt.Printf("# Read-only token for Grafana: %s\n", readToken)
+}
+
+// ensureBucket creates the bucket if it is missing and corrects its retention
+// if it differs, returning its ID.
+func ensureBucket(ctx context.Context, buckets api.BucketsAPI, orgID string, spec bucketSpec) (string, error) {
+	rule := domain.RetentionRule{EverySeconds: int64(spec.retention.Seconds())}
+	existing, err := buckets.FindBucketByName(ctx, spec.name)
+	if err != nil && !bucketNotFound(err) {
+		return "", err
+	}
+	// A bucket of the same name in another organisation does not count.
+	if err != nil || existing.OrgID == nil || *existing.OrgID != orgID {
+		b, err := buckets.CreateBucketWithNameWithID(ctx, orgID, spec.name, rule)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("Created bucket %s (retention %s)", spec.name, retentionString(spec.retention))
+		return *b.Id, nil
+	}
+
+	b := *existing
+	var current int64
+	if len(b.RetentionRules) > 0 {
+		current = b.RetentionRules[0].EverySeconds
+	}
+	if current == rule.EverySeconds {
+		log.Printf("Bucket %s exists (retention %s)", spec.name, retentionString(spec.retention))
+		return *b.Id, nil
+	}
+	b.RetentionRules = domain.RetentionRules{rule}
+	if _, err := buckets.UpdateBucket(ctx, &b); err != nil {
+		return "", err
+	}
+	log.Printf("Updated bucket %s retention from %s to %s", spec.name,
+		retentionString(time.Duration(current)*time.Second), retentionString(spec.retention))
+	return *b.Id, nil
+}
+
+// bucketNotFound reports whether err is FindBucketByName finding no bucket.
+// The client reports an empty result with an error of its own, and some
+// InfluxDB versions answer a name lookup with 404.
+func bucketNotFound(err error) bool {
+	var httpErr *influxhttp.Error
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusNotFound {
+		return true
+	}
+	return strings.HasSuffix(err.Error(), " not found")
+}
+
+// ensureToken returns the active token with the given description and exactly
+// the given permissions, creating one if there is none. InfluxDB may not show
+// the value of an existing token; the returned string then says so.
+func ensureToken(ctx context.Context, auths api.AuthorizationsAPI, orgID, description string, perms []domain.Permission) (string, error) {
+	existing, err := auths.FindAuthorizationsByOrgID(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	var stale bool
+	for _, a := range *existing {
+		if a.Description == nil || *a.Description != description {
+			continue
+		}
+		if a.Status != nil && *a.Status != domain.AuthorizationUpdateRequestStatusActive {
+			continue
+		}
+		if a.Permissions == nil || !samePermissions(*a.Permissions, perms) {
+			stale = true
+			continue
+		}
+		log.Printf("Token %q exists", description)
+		if a.Token == nil || *a.Token == "" {
+			return fmt.Sprintf("(hidden; delete the %q token and rerun to get a new one)", description), nil
+		}
+		return *a.Token, nil
+	}
+	if stale {
+		log.Printf("Token %q exists with other permissions; creating a new one. Delete the old one once nothing uses it.", description)
+	}
+
+	a, err := auths.CreateAuthorization(ctx, &domain.Authorization{
+		AuthorizationUpdateRequest: domain.AuthorizationUpdateRequest{Description: &description},
+		OrgID:                      &orgID,
+		Permissions:                &perms,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Created token %q", description)
+	return *a.Token, nil
+}
+
+func bucketPermission(action domain.PermissionAction, orgID, bucketID string) domain.Permission {
+	return domain.Permission{
+		Action:   action,
+		Resource: domain.Resource{Type: domain.ResourceTypeBuckets, Id: &bucketID, OrgID: &orgID},
+	}
+}
+
+// samePermissions reports whether a and b grant the same actions on the same
+// resources, in any order.
+func samePermissions(a, b []domain.Permission) bool {
+	keys := func(perms []domain.Permission) []string {
+		var out []string
+		for _, p := range perms {
+			var id string
+			if p.Resource.Id != nil {
+				id = *p.Resource.Id
+			}
+			out = append(out, string(p.Action)+":"+string(p.Resource.Type)+":"+id)
+		}
+		slices.Sort(out)
+		return out
+	}
+	return slices.Equal(keys(a), keys(b))
+}
+
+func retentionString(d time.Duration) string {
+	if d == 0 {
+		return "forever"
+	}
+	return fmt.Sprintf("%d days", int(d.Hours()/24))
+}
@@ -0,0 +1,165 @@
+package fronius
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// archiveChunkDays is the longest span the datalogger accepts in one
+// GetArchiveData request.
+const archiveChunkDays = 15
+
+// Archive channels of GetArchiveData for inverters. The datalogger records
+// them every 5 minutes by default.
+const (
+	ChannelEnergyProduced = "EnergyReal_WAC_Sum_Produced" // Wh produced in the interval
+	ChannelPowerAC        = "PowerReal_PAC_Sum"           // W
+	ChannelVoltageAC      = "Voltage_AC_Phase_1"          // V
+	ChannelVoltageACL2    = "Voltage_AC_Phase_2"          // V
+	ChannelVoltageACL3    = "Voltage_AC_Phase_3"          // V
+	ChannelCurrentAC      = "Current_AC_Phase_1"          // A
+	ChannelCurrentACL2    = "Current_AC_Phase_2"          // A
+	ChannelCurrentACL3    = "Current_AC_Phase_3"          // A
+	ChannelVoltageDC1     = "Voltage_DC_String_1"         // V
+	ChannelCurrentDC1     = "Current_DC_String_1"         // A
+	ChannelVoltageDC2     = "Voltage_DC_String_2"         // V
+	ChannelCurrentDC2     = "Current_DC_String_2"         // A
+)
+
+// archiveChannelData holds time-series values for one data channel, keyed by
+// seconds since the device's Start.
+type archiveChannelData struct {
+	Unit   string             `json:"Unit"`
+	Values map[string]float64 `json:"Values"`
+}
+
+// archiveDeviceData holds channel data for one device in the archive response.
+type archiveDeviceData struct {
+	Start string                        `json:"Start"`
+	Data  map[string]archiveChannelData `json:"Data"`
+}
+
+// archiveResponse is the full envelope from GetArchiveData.
+type archiveResponse struct {
+	Body struct {
+		Data map[string]archiveDeviceData `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+	} `json:"Head"`
+}
+
+// ArchiveRecord is one archived sample: the value of every requested channel
+// the datalogger recorded at Time.
+type ArchiveRecord struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// FetchMonthEnergy returns the total energy produced in the current calendar
+// month (Wh) by the inverter with the given DeviceId, by summing daily values
+// from GetArchiveData. Unlike the running total_energy difference stored in
+// InfluxDB, this reflects the inverter's own historical records regardless of
+// when this service started running.
+//
+// The inverter limits archive queries to 15 days, so months longer than that
+// are fetched in chunks and summed.
+func (c *Client) FetchMonthEnergy(ctx context.Context, deviceID int, now time.Time) (float64, error) {
+	var total float64
+	chunkStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	for !chunkStart.After(now) {
+		chunkEnd := chunkStart.AddDate(0, 0, archiveChunkDays-1)
+		if chunkEnd.After(now) {
+			chunkEnd = now
+		}
+		result, err := c.getArchive(ctx, deviceID, chunkStart, chunkEnd, "DailySum", ChannelEnergyProduced)
+		if err != nil {
+			return 0, err
+		}
+		for _, device := range result.Body.Data {
+			if ch, ok := device.Data[ChannelEnergyProduced]; ok {
+				for _, v := range ch.Values {
+					total += v
+				}
+			}
+		}
+		chunkStart = chunkStart.AddDate(0, 0, archiveChunkDays)
+	}
+	return total, nil
+}
+
+// FetchArchive returns the detailed archive of the given channels for the
+// inverter with the given DeviceId, covering the whole days from start to end
+// inclusive, in the datalogger's time zone. Records are in time order; a
+// channel the datalogger did not record is absent from that record's Values.
+//
+// Spans longer than 15 days are fetched in chunks, like FetchMonthEnergy.
+func (c *Client) FetchArchive(ctx context.Context, deviceID int, start, end time.Time, channels ...string) ([]ArchiveRecord, error) {
+	byTime := make(map[time.Time]map[string]float64)
+	for chunkStart := start; !chunkStart.After(end); chunkStart = chunkStart.AddDate(0, 0, archiveChunkDays) {
+		chunkEnd := chunkStart.AddDate(0, 0, archiveChunkDays-1)
+		if chunkEnd.After(end) {
+			chunkEnd = end
+		}
+		result, err := c.getArchive(ctx, deviceID, chunkStart, chunkEnd, "Detail", channels...)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range result.Body.Data {
+			origin, err := time.Parse(time.RFC3339, device.Start)
+			if err != nil {
+				return nil, fmt.Errorf("fronius: archive start %q: %w", device.Start, err)
+			}
+			for channel, ch := range device.Data {
+				for offset, v := range ch.Values {
+					secs, err := strconv.Atoi(offset)
+					if err != nil {
+						return nil, fmt.Errorf("fronius: archive offset %q: %w", offset, err)
+					}
+					t := origin.Add(time.Duration(secs) * time.Second)
+					if byTime[t] == nil {
+						byTime[t] = make(map[string]float64)
+					}
+					byTime[t][channel] = v
+				}
+			}
+		}
+	}
+
+	records := make([]ArchiveRecord, 0, len(byTime))
+	for t, values := range byTime {
+		records = append(records, ArchiveRecord{Time: t, Values: values})
+	}
+	slices.SortFunc(records, func(a, b ArchiveRecord) int { return a.Time.Compare(b.Time) })
+	return records, nil
+}
+
+// getArchive requests one chunk of GetArchiveData. Archive requests can be
+// slow, so they use the archive client.
+func (c *Client) getArchive(ctx context.Context, deviceID int, start, end time.Time, seriesType string, channels ...string) (*archiveResponse, error) {
+	q := url.Values{
+		"Scope":       {"Device"},
+		"DeviceClass": {"Inverter"},
+		"DeviceId":    {strconv.Itoa(deviceID)},
+		"StartDate":   {start.Format("02.01.2006")},
+		"EndDate":     {end.Format("02.01.2006")},
+		"SeriesType":  {seriesType},
+		"Channel":     channels,
+	}
+	rawURL := c.baseURL + "/solar_api/v1/GetArchiveData.cgi?" + q.Encode()
+
+	var result archiveResponse
+	if err := c.getJSON(ctx, c.archiveClient, rawURL, &result); err != nil {
+		return nil, err
+	}
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
+	}
+	return &result, nil
+}
@@ -23,18 +23,77 @@ type DeviceStatus struct {
 	StatusCode int `json:"StatusCode"`
 }
 
+// APIError is returned when the Solar API responds with a non-zero Head.Status.Code,
+// for example when a device or data collection is not supported.
+type APIError struct {
+	Code int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("fronius: API error code %d", e.Code)
+}
+
 // CommonInverterData holds all fields from DataCollection=CommonInverterData.
+// IDC and UDC are the first MPPT tracker; inverters with more trackers also
+// report IDC_2/UDC_2 and so on, which are nil otherwise.
 type CommonInverterData struct {
-	PAC          InverterValue `json:"PAC"`
-	IAC          InverterValue `json:"IAC"`
-	UAC          InverterValue `json:"UAC"`
-	FAC          InverterValue `json:"FAC"`
-	IDC          InverterValue `json:"IDC"`
-	UDC          InverterValue `json:"UDC"`
-	DayEnergy    InverterValue `json:"DAY_ENERGY"`
-	YearEnergy   InverterValue `json:"YEAR_ENERGY"`
-	TotalEnergy  InverterValue `json:"TOTAL_ENERGY"`
-	DeviceStatus DeviceStatus  `json:"DeviceStatus"`
+	PAC          InverterValue  `json:"PAC"`
+	IAC          InverterValue  `json:"IAC"`
+	UAC          InverterValue  `json:"UAC"`
+	FAC          InverterValue  `json:"FAC"`
+	IDC          InverterValue  `json:"IDC"`
+	UDC          InverterValue  `json:"UDC"`
+	IDC2         *InverterValue `json:"IDC_2"`
+	UDC2         *InverterValue `json:"UDC_2"`
+	IDC3         *InverterValue `json:"IDC_3"`
+	UDC3         *InverterValue `json:"UDC_3"`
+	IDC4         *InverterValue `json:"IDC_4"`
+	UDC4         *InverterValue `json:"UDC_4"`
+	DayEnergy    InverterValue  `json:"DAY_ENERGY"`
+	YearEnergy   InverterValue  `json:"YEAR_ENERGY"`
+	TotalEnergy  InverterValue  `json:"TOTAL_ENERGY"`
+	DeviceStatus DeviceStatus   `json:"DeviceStatus"`
+}
+
+// DCInput is the DC input of one MPPT tracker (one string or set of strings).
+type DCInput struct {
+	Current InverterValue
+	Voltage InverterValue
+}
+
+// DCInputs returns the DC inputs reported by the inverter, one per MPPT
+// tracker, starting with IDC/UDC.
+func (d *CommonInverterData) DCInputs() []DCInput {
+	inputs := []DCInput{{Current: d.IDC, Voltage: d.UDC}}
+	for _, in := range [][2]*InverterValue{{d.IDC2, d.UDC2}, {d.IDC3, d.UDC3}, {d.IDC4, d.UDC4}} {
+		if in[0] == nil || in[1] == nil {
+			break
+		}
+		inputs = append(inputs, DCInput{Current: *in[0], Voltage: *in[1]})
+	}
+	return inputs
+}
+
+// ThreePhaseInverterData holds per-phase fields from DataCollection=3PInverterData.
+type ThreePhaseInverterData struct {
+	IACL1 InverterValue `json:"IAC_L1"`
+	IACL2 InverterValue `json:"IAC_L2"`
+	IACL3 InverterValue `json:"IAC_L3"`
+	UACL1 InverterValue `json:"UAC_L1"`
+	UACL2 InverterValue `json:"UAC_L2"`
+	UACL3 InverterValue `json:"UAC_L3"`
+}
+
+// ThreePhaseResponse is the full envelope for DataCollection=3PInverterData.
+type ThreePhaseResponse struct {
+	Body struct {
+		Data ThreePhaseInverterData `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+	} `json:"Head"`
 }
 
 // RealtimeDataResponse is the full envelope from the Fronius Solar API.
@@ -46,10 +105,27 @@ type RealtimeDataResponse struct {
 		Status struct {
 			Code int `json:"Code"`
 		} `json:"Status"`
+		// Timestamp is the datalogger's clock when it answered, with its
+		// UTC offset, e.g. "2026-06-01T12:00:05+02:00".
+		Timestamp string `json:"Timestamp"`
 	} `json:"Head"`
 }
 
-// Client is an HTTP client scoped to a single Fronius inverter.
+// ParseTimestamp parses a Head.Timestamp. Timestamps normally carry a UTC
+// offset; one without is taken to be in loc.
+func ParseTimestamp(s string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02T15:04:05", s, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("fronius: invalid timestamp %q", s)
+	}
+	return t, nil
+}
+
+// Client is an HTTP client scoped to a single Fronius datalogger, which may
+// expose several inverters distinguished by DeviceId.
 type Client struct {
 	baseURL       string
 	httpClient    *http.Client
@@ -68,121 +144,58 @@ func New(baseURL string, httpClient *http.Client) *Client {
 	}
 }
 
-// Fetch retrieves CommonInverterData from the inverter. It returns an error if the HTTP
-// request fails, the response cannot be decoded, or the API-level status code is non-zero.
-// Check DeviceStatus.StatusCode before writing metrics — a successful fetch does not imply
-// the inverter is producing power.
-func (c *Client) Fetch(ctx context.Context) (*RealtimeDataResponse, error) {
-	url := c.baseURL + "/solar_api/v1/GetInverterRealtimeData.cgi?Scope=Device&DeviceId=1&DataCollection=CommonInverterData"
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("fronius: build request: %w", err)
-	}
-
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("fronius: do request: %w", err)
-	}
-	defer resp.Body.Close()
+// Fetch retrieves CommonInverterData from the inverter with the given DeviceId.
+// It returns an error if the HTTP request fails, the response cannot be decoded,
+// or the API-level status code is non-zero. Check DeviceStatus.StatusCode before
+// writing metrics — a successful fetch does not imply the inverter is producing power.
+func (c *Client) Fetch(ctx context.Context, deviceID int) (*RealtimeDataResponse, error) {
+	url := fmt.Sprintf("%s/solar_api/v1/GetInverterRealtimeData.cgi?Scope=Device&DeviceId=%d&DataCollection=CommonInverterData", c.baseURL, deviceID)
 
 	var result RealtimeDataResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return nil, fmt.Errorf("fronius: decode response: %w", err)
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
 	}
 
 	if result.Head.Status.Code != 0 {
-		return nil, fmt.Errorf("fronius: API error code %d", result.Head.Status.Code)
+		return nil, &APIError{Code: result.Head.Status.Code}
 	}
 
 	return &result, nil
 }
 
-// archiveChannelData holds time-series values for one data channel.
-type archiveChannelData struct {
-	Unit   string             `json:"Unit"`
-	Values map[string]float64 `json:"Values"`
-}
-
-// archiveDeviceData holds channel data for one device in the archive response.
-type archiveDeviceData struct {
-	Data map[string]archiveChannelData `json:"Data"`
-}
+// FetchThreePhase retrieves 3PInverterData from the inverter with the given
+// DeviceId. Single-phase inverters respond with an *APIError.
+func (c *Client) FetchThreePhase(ctx context.Context, deviceID int) (*ThreePhaseResponse, error) {
+	url := fmt.Sprintf("%s/solar_api/v1/GetInverterRealtimeData.cgi?Scope=Device&DeviceId=%d&DataCollection=3PInverterData", c.baseURL, deviceID)
 
-// archiveResponse is the full envelope from GetArchiveData.
-type archiveResponse struct {
-	Body struct {
-		Data map[string]archiveDeviceData `json:"Data"`
-	} `json:"Body"`
-	Head struct {
-		Status struct {
-			Code int `json:"Code"`
-		} `json:"Status"`
-	} `json:"Head"`
-}
+	var result ThreePhaseResponse
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
+	}
 
-// FetchMonthEnergy returns the total energy produced in the current calendar
-// month (Wh) by summing daily values from GetArchiveData. Unlike the running
-// total_energy difference stored in InfluxDB, this reflects the inverter's own
-// historical records regardless of when this service started running.
-//
-// The inverter limits archive queries to 15 days, so months longer than that
-// are fetched in chunks and summed.
-func (c *Client) FetchMonthEnergy(ctx context.Context, now time.Time) (float64, error) {
-	const chunkDays = 15
-
-	var total float64
-	chunkStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
-	for !chunkStart.After(now) {
-		chunkEnd := chunkStart.AddDate(0, 0, chunkDays-1)
-		if chunkEnd.After(now) {
-			chunkEnd = now
-		}
-		wh, err := c.fetchArchiveChunk(ctx, chunkStart, chunkEnd)
-		if err != nil {
-			return 0, err
-		}
-		total += wh
-		chunkStart = chunkStart.AddDate(0, 0, chunkDays)
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
 	}
-	return total, nil
-}
 
-func (c *Client) fetchArchiveChunk(ctx context.Context, start, end time.Time) (float64, error) {
-	url := fmt.Sprintf(
-		"%s/solar_api/v1/GetArchiveData.cgi?Scope=Device&DeviceClass=Inverter&DeviceId=1&StartDate=%s&EndDate=%s&Channel=EnergyReal_WAC_Sum_Produced&SeriesType=DailySum",
-		c.baseURL,
-		start.Format("02.01.2006"),
-		end.Format("02.01.2006"),
-	)
+	return &result, nil
+}
 
+// getJSON issues a GET request with httpClient and decodes the JSON response into dst.
+func (c *Client) getJSON(ctx context.Context, httpClient *http.Client, url string, dst any) error {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("fronius: build archive request: %w", err)
+		return fmt.Errorf("fronius: build request: %w", err)
 	}
 
-	resp, err := c.archiveClient.Do(req)
+	resp, err := httpClient.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("fronius: do archive request: %w", err)
+		return fmt.Errorf("fronius: do request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	var result archiveResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return 0, fmt.Errorf("fronius: decode archive response: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(dst); err != nil {
+		return fmt.Errorf("fronius: decode response: %w", err)
 	}
 
-	if result.Head.Status.Code != 0 {
-		return 0, fmt.Errorf("fronius: archive API error code %d", result.Head.Status.Code)
-	}
-
-	var total float64
-	for _, device := range result.Body.Data {
-		if ch, ok := device.Data["EnergyReal_WAC_Sum_Produced"]; ok {
-			for _, v := range ch.Values {
-				total += v
-			}
-		}
-	}
-	return total, nil
+	return nil
 }
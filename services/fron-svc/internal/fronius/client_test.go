@@ -0,0 +1,294 @@
+package fronius_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+	"go.local/services/fron-svc/internal/fronius/froniustest"
+)
+
+// loc is the datalogger's time zone in these tests.
+var loc = time.FixedZone("CET", 3600)
+
+// noon is solar noon of the simulated day: half way between 06:00 and 20:00.
+var noon = time.Date(2026, 6, 15, 13, 0, 0, 0, loc)
+
+func newClient(t *testing.T, cfg froniustest.Config, timeout time.Duration) (*froniustest.Simulator, *fronius.Client) {
+	t.Helper()
+	cfg.Location = loc
+	if cfg.Now == nil {
+		cfg.Now = func() time.Time { return noon }
+	}
+	sim, srv := froniustest.NewServer(cfg)
+	t.Cleanup(srv.Close)
+	return sim, fronius.New(srv.URL, &http.Client{Timeout: timeout})
+}
+
+func TestFetch(t *testing.T) {
+	sim, c := newClient(t, froniustest.Config{Trackers: 2, ClockOffset: 90 * time.Second}, time.Second)
+
+	resp, err := c.Fetch(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := resp.Body.Data
+	if d.DeviceStatus.StatusCode != fronius.StatusRunning {
+		t.Errorf("StatusCode = %d, want running", d.DeviceStatus.StatusCode)
+	}
+	if want := math.Round(sim.Power(noon)); d.PAC.Value != want || d.PAC.Unit != "W" {
+		t.Errorf("PAC = %v %s, want %v W", d.PAC.Value, d.PAC.Unit, want)
+	}
+	if want := math.Round(sim.DayEnergy(noon)); d.DayEnergy.Value != want {
+		t.Errorf("DAY_ENERGY = %v, want %v", d.DayEnergy.Value, want)
+	}
+	if d.YearEnergy.Value <= d.DayEnergy.Value || d.TotalEnergy.Value <= d.YearEnergy.Value {
+		t.Errorf("energy counters out of order: day %v, year %v, total %v", d.DayEnergy.Value, d.YearEnergy.Value, d.TotalEnergy.Value)
+	}
+	if inputs := d.DCInputs(); len(inputs) != 2 {
+		t.Errorf("%d DC inputs, want 2", len(inputs))
+	}
+
+	ts, err := fronius.ParseTimestamp(resp.Head.Timestamp, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := noon.Add(90 * time.Second); !ts.Equal(want) {
+		t.Errorf("Head.Timestamp = %s, want %s", ts, want)
+	}
+}
+
+func TestFetchAtNight(t *testing.T) {
+	night := time.Date(2026, 6, 15, 2, 0, 0, 0, loc)
+	_, c := newClient(t, froniustest.Config{Now: func() time.Time { return night }}, time.Second)
+
+	resp, err := c.Fetch(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := resp.Body.Data.DeviceStatus; st.StatusCode == fronius.StatusRunning || resp.Body.Data.PAC.Value != 0 {
+		t.Errorf("at night: status %d, PAC %v; want asleep with no output", st.StatusCode, resp.Body.Data.PAC.Value)
+	}
+}
+
+func TestFetchFault(t *testing.T) {
+	sim, c := newClient(t, froniustest.Config{}, time.Second)
+	sim.SetError(567)
+
+	resp, err := c.Fetch(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := resp.Body.Data.DeviceStatus
+	if st.StatusCode == fronius.StatusRunning || st.ErrorCode != 567 {
+		t.Errorf("status %d error %d, want a fault with error 567", st.StatusCode, st.ErrorCode)
+	}
+	if desc := st.Describe(); desc.State != fronius.StateFault {
+		t.Errorf("state = %s, want %s", desc.State, fronius.StateFault)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for _, tc := range []struct {
+		status, error int
+		want          fronius.State
+	}{
+		{7, 0, fronius.StateRunning},
+		{8, 0, fronius.StateStandby},
+		{7, 567, fronius.StateRunning},
+		{8, 102, fronius.StateFault},
+		{10, 0, fronius.StateFault},
+		{255, 0, fronius.StateUnknown},
+		// Not enough sun at dusk and dawn is not a fault.
+		{8, 306, fronius.StateStandby},
+		{3, 307, fronius.StateStartup},
+		{13, 306, fronius.StateStandby},
+	} {
+		st := fronius.DeviceStatus{StatusCode: tc.status, ErrorCode: tc.error}.Describe()
+		if st.State != tc.want {
+			t.Errorf("status %d, error %d: state = %s, want %s", tc.status, tc.error, st.State, tc.want)
+		}
+	}
+
+	st := fronius.DeviceStatus{StatusCode: 8, ErrorCode: 306}.Describe()
+	if st.Error != "Insufficient PV power for feeding in" {
+		t.Errorf("error = %q, want the 306 description", st.Error)
+	}
+}
+
+func TestFetchAPIErrors(t *testing.T) {
+	sim, c := newClient(t, froniustest.Config{}, time.Second)
+	ctx := context.Background()
+
+	var apiErr *fronius.APIError
+	if _, err := c.Fetch(ctx, 2); !errors.As(err, &apiErr) || apiErr.Code != froniustest.CodeDeviceNotAvailable {
+		t.Errorf("unknown DeviceId: err = %v, want API error %d", err, froniustest.CodeDeviceNotAvailable)
+	}
+
+	sim.SetAPIError(8)
+	if _, err := c.Fetch(ctx, 1); !errors.As(err, &apiErr) || apiErr.Code != 8 {
+		t.Errorf("SetAPIError(8): err = %v, want API error 8", err)
+	}
+	sim.SetAPIError(0)
+	if _, err := c.Fetch(ctx, 1); err != nil {
+		t.Errorf("after clearing the API error: %v", err)
+	}
+}
+
+func TestFetchThreePhase(t *testing.T) {
+	_, single := newClient(t, froniustest.Config{}, time.Second)
+	var apiErr *fronius.APIError
+	if _, err := single.FetchThreePhase(context.Background(), 1); !errors.As(err, &apiErr) || apiErr.Code != froniustest.CodeNotSupported {
+		t.Errorf("single-phase inverter: err = %v, want API error %d", err, froniustest.CodeNotSupported)
+	}
+
+	sim, three := newClient(t, froniustest.Config{ThreePhase: true}, time.Second)
+	resp, err := three.FetchThreePhase(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := resp.Body.Data
+	if d.UACL1.Value == 0 || d.UACL3.Value == 0 {
+		t.Errorf("phase voltages missing: %+v", d)
+	}
+	perPhase := sim.Power(noon) / 3 / d.UACL2.Value
+	if math.Abs(d.IACL2.Value-perPhase) > 0.01 {
+		t.Errorf("IAC_L2 = %v, want %.2f", d.IACL2.Value, perPhase)
+	}
+}
+
+// archiveSpans records the StartDate and EndDate of every archive request.
+type archiveSpans struct {
+	next http.Handler
+
+	mu    sync.Mutex
+	spans [][2]time.Time
+}
+
+func (a *archiveSpans) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	start, _ := time.ParseInLocation("02.01.2006", q.Get("StartDate"), loc)
+	end, _ := time.ParseInLocation("02.01.2006", q.Get("EndDate"), loc)
+	a.mu.Lock()
+	a.spans = append(a.spans, [2]time.Time{start, end})
+	a.mu.Unlock()
+	a.next.ServeHTTP(w, r)
+}
+
+func TestFetchArchive(t *testing.T) {
+	sim := froniustest.New(froniustest.Config{Location: loc, Now: func() time.Time { return noon }})
+	spans := &archiveSpans{next: sim}
+	srv := httptest.NewServer(spans)
+	defer srv.Close()
+	c := fronius.New(srv.URL, &http.Client{Timeout: time.Second})
+
+	first := time.Date(2026, 5, 27, 0, 0, 0, 0, loc)
+	last := time.Date(2026, 6, 14, 0, 0, 0, 0, loc) // 19 days
+	records, err := c.FetchArchive(context.Background(), 1, first, last, fronius.ChannelEnergyProduced, fronius.ChannelPowerAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(spans.spans) != 2 {
+		t.Fatalf("%d archive requests, want 2", len(spans.spans))
+	}
+	for _, s := range spans.spans {
+		if days := int(s[1].Sub(s[0]).Hours()/24) + 1; days > 15 {
+			t.Errorf("request for %s to %s spans %d days, over the 15-day limit", s[0].Format(time.DateOnly), s[1].Format(time.DateOnly), days)
+		}
+	}
+	if got := spans.spans[1][1]; !got.Equal(last) {
+		t.Errorf("last request ends %s, want %s", got.Format(time.DateOnly), last.Format(time.DateOnly))
+	}
+
+	perDay := make(map[string]float64)
+	for i, r := range records {
+		if i > 0 && !r.Time.After(records[i-1].Time) {
+			t.Fatalf("records out of order at %s", r.Time)
+		}
+		if r.Time.Sub(r.Time.Truncate(5*time.Minute)) != 0 {
+			t.Errorf("record at %s is not on a 5-minute boundary", r.Time)
+		}
+		// A record covers the 5 minutes before it.
+		perDay[r.Time.Add(-time.Second).In(loc).Format(time.DateOnly)] += r.Values[fronius.ChannelEnergyProduced]
+	}
+	if len(perDay) != 19 {
+		t.Errorf("records on %d days, want 19", len(perDay))
+	}
+	full := sim.DayEnergy(time.Date(2026, 6, 1, 23, 0, 0, 0, loc))
+	for day, wh := range perDay {
+		if math.Abs(wh-full) > 0.01*full {
+			t.Errorf("%s: archive sums to %.0f Wh, want %.0f", day, wh, full)
+		}
+	}
+}
+
+func TestFetchMonthEnergy(t *testing.T) {
+	sim, c := newClient(t, froniustest.Config{}, time.Second)
+	got, err := c.FetchMonthEnergy(context.Background(), 1, noon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := sim.DayEnergy(time.Date(2026, 6, 1, 23, 0, 0, 0, loc))
+	want := 14*full + sim.DayEnergy(noon) // 1-14 June, then today so far
+	if math.Abs(got-want) > 20 {
+		t.Errorf("month energy = %.0f Wh, want %.0f", got, want)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2026, 6, 1, 10, 0, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		in   string
+		loc  *time.Location
+		want time.Time
+	}{
+		{"2026-06-01T12:00:05+02:00", time.UTC, want},
+		{"2026-06-01T10:00:05Z", loc, want},
+		{"2026-06-01T11:00:05", loc, want},
+	} {
+		got, err := fronius.ParseTimestamp(tc.in, tc.loc)
+		if err != nil {
+			t.Errorf("%q: %v", tc.in, err)
+			continue
+		}
+		if !got.Equal(tc.want) {
+			t.Errorf("%q in %s = %s, want %s", tc.in, tc.loc, got, tc.want)
+		}
+	}
+	for _, in := range []string{"", "yesterday", "2026-06-01"} {
+		if _, err := fronius.ParseTimestamp(in, loc); err == nil {
+			t.Errorf("%q: want an error", in)
+		}
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	sim, c := newClient(t, froniustest.Config{}, 100*time.Millisecond)
+	ctx := context.Background()
+
+	sim.SetHang(true)
+	start := time.Now()
+	if _, err := c.Fetch(ctx, 1); err == nil {
+		t.Error("hanging datalogger: want an error")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("gave up after %s, want about the 100ms client timeout", d)
+	}
+
+	sim.SetHang(false)
+	sim.SetLatency(20 * time.Millisecond)
+	if _, err := c.Fetch(ctx, 1); err != nil {
+		t.Errorf("slow but in time: %v", err)
+	}
+	sim.SetLatency(200 * time.Millisecond)
+	if _, err := c.Fetch(ctx, 1); err == nil {
+		t.Error("slower than the timeout: want an error")
+	}
+}
@@ -0,0 +1,82 @@
+package fronius
+
+import (
+	"context"
+	"slices"
+	"strconv"
+)
+
+// APIVersion is the response from GetAPIVersion, which has no Head/Body envelope.
+type APIVersion struct {
+	APIVersion         int    `json:"APIVersion"`
+	BaseURL            string `json:"BaseURL"`
+	CompatibilityRange string `json:"CompatibilityRange"`
+}
+
+// FetchAPIVersion retrieves the Solar API version supported by the datalogger.
+func (c *Client) FetchAPIVersion(ctx context.Context) (*APIVersion, error) {
+	var result APIVersion
+	if err := c.getJSON(ctx, c.httpClient, c.baseURL+"/solar_api/GetAPIVersion.cgi", &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ActiveDevice describes one device reported by GetActiveDeviceInfo.
+type ActiveDevice struct {
+	DT     int    `json:"DT"` // device type; -1 when unknown
+	Serial string `json:"Serial"`
+}
+
+// ActiveDevices maps DeviceId (as a string) to device for one device class.
+type ActiveDevices map[string]ActiveDevice
+
+// IDs returns the DeviceIds in ascending order, skipping any that are not numeric.
+func (d ActiveDevices) IDs() []int {
+	ids := make([]int, 0, len(d))
+	for k := range d {
+		if id, err := strconv.Atoi(k); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// ActiveDeviceInfo lists the devices connected to a datalogger, by class.
+type ActiveDeviceInfo struct {
+	Inverter      ActiveDevices `json:"Inverter"`
+	Meter         ActiveDevices `json:"Meter"`
+	Storage       ActiveDevices `json:"Storage"`
+	SensorCard    ActiveDevices `json:"SensorCard"`
+	StringControl ActiveDevices `json:"StringControl"`
+	Ohmpilot      ActiveDevices `json:"Ohmpilot"`
+}
+
+// ActiveDeviceInfoResponse is the full envelope from GetActiveDeviceInfo.
+type ActiveDeviceInfoResponse struct {
+	Body struct {
+		Data ActiveDeviceInfo `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+	} `json:"Head"`
+}
+
+// FetchActiveDevices retrieves every device class connected to the datalogger.
+func (c *Client) FetchActiveDevices(ctx context.Context) (*ActiveDeviceInfoResponse, error) {
+	url := c.baseURL + "/solar_api/v1/GetActiveDeviceInfo.cgi?DeviceClass=System"
+
+	var result ActiveDeviceInfoResponse
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,610 @@
+// Package froniustest simulates a Fronius datalogger for local development and
+// tests. It serves the Solar API v1 endpoints the fronius package uses, with
+// values that follow a configurable solar curve, and can be told to report
+// inverter faults, API errors, slow responses or no response at all.
+package froniustest
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Solar API Head.Status.Code values the simulator answers with.
+const (
+	CodeOK                 = 0
+	CodeNotSupported       = 11
+	CodeDeviceNotAvailable = 12
+)
+
+// Inverter DeviceStatus.StatusCode values the simulator reports.
+const (
+	statusRunning = 7
+	statusFault   = 10
+	statusSleep   = 13
+)
+
+const (
+	gridVoltage   = 230.0
+	gridFrequency = 50.0
+	dcVoltage     = 420.0
+	efficiency    = 0.97
+
+	// archiveStep is how often the simulated datalogger archives a sample.
+	archiveStep = 5 * time.Minute
+)
+
+// Config describes the simulated installation. The zero value is a single
+// 5 kW single-phase inverter with one MPPT tracker, producing between 06:00
+// and 20:00 local time.
+type Config struct {
+	Inverters  int           // inverters at DeviceId 1..n; default 1
+	PeakPower  float64       // AC output per inverter at solar noon, W; default 5000
+	Sunrise    time.Duration // time of day production starts; default 6h
+	Sunset     time.Duration // time of day production ends; default 20h
+	Trackers   int           // MPPT trackers per inverter, 1-4; default 1
+	ThreePhase bool          // answer 3PInverterData requests
+
+	Meter   bool    // a grid meter at DeviceId 0
+	Storage bool    // a battery at DeviceId 0
+	Load    float64 // household consumption for the meter and power flow, W; default 500
+
+	// ClockOffset is how far the datalogger's clock runs ahead of Now, as
+	// reported in Head.Timestamp.
+	ClockOffset time.Duration
+	Location    *time.Location   // the datalogger's time zone; default time.Local
+	Now         func() time.Time // default time.Now
+}
+
+// Simulator is an http.Handler that answers like a Fronius datalogger. Its
+// fault settings can be changed while it serves; it is safe for concurrent use.
+type Simulator struct {
+	cfg Config
+	mux *http.ServeMux
+
+	mu        sync.Mutex
+	errorCode int           // inverter fault reported by every inverter
+	apiCode   int           // Head.Status.Code for every realtime request
+	latency   time.Duration // delay before every response
+	hang      bool          // never respond
+}
+
+// New returns a Simulator for cfg.
+func New(cfg Config) *Simulator {
+	cfg.Inverters = cmp.Or(cfg.Inverters, 1)
+	cfg.PeakPower = cmp.Or(cfg.PeakPower, 5000)
+	cfg.Sunrise = cmp.Or(cfg.Sunrise, 6*time.Hour)
+	cfg.Sunset = cmp.Or(cfg.Sunset, 20*time.Hour)
+	cfg.Trackers = min(max(cfg.Trackers, 1), 4)
+	cfg.Load = cmp.Or(cfg.Load, 500)
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	s := &Simulator{cfg: cfg, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /solar_api/GetAPIVersion.cgi", s.serveAPIVersion)
+	s.mux.HandleFunc("GET /solar_api/v1/GetActiveDeviceInfo.cgi", s.serveActiveDevices)
+	s.mux.HandleFunc("GET /solar_api/v1/GetInverterRealtimeData.cgi", s.serveInverter)
+	s.mux.HandleFunc("GET /solar_api/v1/GetArchiveData.cgi", s.serveArchive)
+	s.mux.HandleFunc("GET /solar_api/v1/GetMeterRealtimeData.cgi", s.serveMeter)
+	s.mux.HandleFunc("GET /solar_api/v1/GetPowerFlowRealtimeData.fcgi", s.servePowerFlow)
+	s.mux.HandleFunc("GET /solar_api/v1/GetStorageRealtimeData.cgi", s.serveStorage)
+	return s
+}
+
+// NewServer starts an httptest.Server serving a Simulator for cfg. The caller
+// should call Close on the server when finished.
+func NewServer(cfg Config) (*Simulator, *httptest.Server) {
+	s := New(cfg)
+	return s, httptest.NewServer(s)
+}
+
+// SetError makes every inverter report a fault with the given error code, the
+// state code shown on its display. Zero clears the fault.
+func (s *Simulator) SetError(code int) {
+	s.mu.Lock()
+	s.errorCode = code
+	s.mu.Unlock()
+}
+
+// SetAPIError makes every realtime request answer with the given non-zero
+// Head.Status.Code and no data, as a datalogger does when it cannot reach a
+// device. Zero restores normal answers.
+func (s *Simulator) SetAPIError(code int) {
+	s.mu.Lock()
+	s.apiCode = code
+	s.mu.Unlock()
+}
+
+// SetLatency delays every response by d.
+func (s *Simulator) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	s.latency = d
+	s.mu.Unlock()
+}
+
+// SetHang makes requests block until the client gives up, to exercise
+// timeouts.
+func (s *Simulator) SetHang(hang bool) {
+	s.mu.Lock()
+	s.hang = hang
+	s.mu.Unlock()
+}
+
+// Power returns the AC output of one inverter at t, W: a half sine between
+// sunrise and sunset peaking at PeakPower, and zero at night.
+func (s *Simulator) Power(t time.Time) float64 {
+	x := s.dayFraction(t)
+	if x <= 0 || x >= 1 {
+		return 0
+	}
+	return s.cfg.PeakPower * math.Sin(math.Pi*x)
+}
+
+// DayEnergy returns the energy one inverter has produced on t's day up to t,
+// Wh: the integral of Power since sunrise.
+func (s *Simulator) DayEnergy(t time.Time) float64 {
+	x := min(max(s.dayFraction(t), 0), 1)
+	hours := (s.cfg.Sunset - s.cfg.Sunrise).Hours()
+	return s.cfg.PeakPower * hours / math.Pi * (1 - math.Cos(math.Pi*x))
+}
+
+// dayFraction returns how far t is between sunrise (0) and sunset (1).
+func (s *Simulator) dayFraction(t time.Time) float64 {
+	t = t.In(s.cfg.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.cfg.Location)
+	return float64(t.Sub(midnight)-s.cfg.Sunrise) / float64(s.cfg.Sunset-s.cfg.Sunrise)
+}
+
+// yearEnergy returns the energy one inverter has produced in t's year up to t,
+// Wh, assuming every earlier day was a full day.
+func (s *Simulator) yearEnergy(t time.Time) float64 {
+	t = t.In(s.cfg.Location)
+	return float64(t.YearDay()-1)*s.fullDayEnergy() + s.DayEnergy(t)
+}
+
+// totalEnergy returns the lifetime energy of one inverter up to t, Wh, as if
+// it had been installed three years before t's year.
+func (s *Simulator) totalEnergy(t time.Time) float64 {
+	return 3*365*s.fullDayEnergy() + s.yearEnergy(t)
+}
+
+func (s *Simulator) fullDayEnergy() float64 {
+	return 2 * s.cfg.PeakPower * (s.cfg.Sunset - s.cfg.Sunrise).Hours() / math.Pi
+}
+
+func (s *Simulator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	latency, hang := s.latency, s.hang
+	s.mu.Unlock()
+
+	if hang {
+		<-r.Context().Done()
+		return
+	}
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// now returns the simulated time and the datalogger's Head.Timestamp for it.
+func (s *Simulator) now() (time.Time, string) {
+	t := s.cfg.Now()
+	return t, t.Add(s.cfg.ClockOffset).In(s.cfg.Location).Format(time.RFC3339)
+}
+
+// value is the Unit/Value pair of realtime inverter data.
+type value struct {
+	Unit  string  `json:"Unit"`
+	Value float64 `json:"Value"`
+}
+
+// writeResponse writes the Solar API envelope around data. A non-zero code
+// replaces data with an empty object.
+func (s *Simulator) writeResponse(w http.ResponseWriter, r *http.Request, code int, data any) {
+	_, ts := s.now()
+	var reason string
+	if code != CodeOK {
+		reason = fmt.Sprintf("simulated error %d", code)
+		data = struct{}{}
+	}
+	args := make(map[string]string)
+	for k := range r.URL.Query() {
+		args[k] = r.URL.Query().Get(k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"Body": map[string]any{"Data": data},
+		"Head": map[string]any{
+			"RequestArguments": args,
+			"Status":           map[string]any{"Code": code, "Reason": reason, "UserMessage": ""},
+			"Timestamp":        ts,
+		},
+	})
+}
+
+// realtimeCode returns the Head.Status.Code set with SetAPIError.
+func (s *Simulator) realtimeCode() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.apiCode
+}
+
+func (s *Simulator) serveAPIVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"APIVersion":         1,
+		"BaseURL":            "/solar_api/v1/",
+		"CompatibilityRange": "1.6-3",
+	})
+}
+
+func (s *Simulator) serveActiveDevices(w http.ResponseWriter, r *http.Request) {
+	type device struct {
+		DT     int    `json:"DT"`
+		Serial string `json:"Serial"`
+	}
+	inverters := make(map[string]device)
+	for id := 1; id <= s.cfg.Inverters; id++ {
+		inverters[strconv.Itoa(id)] = device{DT: 1, Serial: fmt.Sprintf("SIM%08d", id)}
+	}
+	data := map[string]map[string]device{
+		"Inverter":      inverters,
+		"Meter":         {},
+		"Storage":       {},
+		"SensorCard":    {},
+		"StringControl": {},
+		"Ohmpilot":      {},
+	}
+	if s.cfg.Meter {
+		data["Meter"]["0"] = device{DT: -1, Serial: "SIMMETER0"}
+	}
+	if s.cfg.Storage {
+		data["Storage"]["0"] = device{DT: -1, Serial: "SIMBATT0"}
+	}
+	s.writeResponse(w, r, CodeOK, data)
+}
+
+// inverterID returns the DeviceId of an inverter request, or false if there
+// is no such inverter.
+func (s *Simulator) inverterID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.URL.Query().Get("DeviceId"))
+	return id, err == nil && id >= 1 && id <= s.cfg.Inverters
+}
+
+func (s *Simulator) serveInverter(w http.ResponseWriter, r *http.Request) {
+	if code := s.realtimeCode(); code != CodeOK {
+		s.writeResponse(w, r, code, nil)
+		return
+	}
+	if _, ok := s.inverterID(r); !ok {
+		s.writeResponse(w, r, CodeDeviceNotAvailable, nil)
+		return
+	}
+	t, _ := s.now()
+	switch r.URL.Query().Get("DataCollection") {
+	case "CommonInverterData":
+		s.writeResponse(w, r, CodeOK, s.commonInverterData(t))
+	case "3PInverterData":
+		if !s.cfg.ThreePhase {
+			s.writeResponse(w, r, CodeNotSupported, nil)
+			return
+		}
+		s.writeResponse(w, r, CodeOK, s.threePhaseData(t))
+	default:
+		s.writeResponse(w, r, CodeNotSupported, nil)
+	}
+}
+
+// commonInverterData builds CommonInverterData at t. Like a real inverter, it
+// reports only its status and energy counters while asleep or faulted.
+func (s *Simulator) commonInverterData(t time.Time) map[string]any {
+	s.mu.Lock()
+	errorCode := s.errorCode
+	s.mu.Unlock()
+
+	pac := s.Power(t)
+	status := statusRunning
+	switch {
+	case errorCode != 0:
+		status = statusFault
+	case pac == 0:
+		status = statusSleep
+	}
+	data := map[string]any{
+		"DAY_ENERGY":   value{"Wh", math.Round(s.DayEnergy(t))},
+		"YEAR_ENERGY":  value{"Wh", math.Round(s.yearEnergy(t))},
+		"TOTAL_ENERGY": value{"Wh", math.Round(s.totalEnergy(t))},
+		"DeviceStatus": map[string]any{"ErrorCode": errorCode, "StatusCode": status},
+	}
+	if status != statusRunning {
+		return data
+	}
+
+	data["PAC"] = value{"W", math.Round(pac)}
+	data["IAC"] = value{"A", round2(pac / gridVoltage)}
+	data["UAC"] = value{"V", gridVoltage}
+	data["FAC"] = value{"Hz", gridFrequency}
+	idc := pac / efficiency / dcVoltage / float64(s.cfg.Trackers)
+	for i := 1; i <= s.cfg.Trackers; i++ {
+		suffix := ""
+		if i > 1 {
+			suffix = "_" + strconv.Itoa(i)
+		}
+		data["IDC"+suffix] = value{"A", round2(idc)}
+		data["UDC"+suffix] = value{"V", dcVoltage}
+	}
+	return data
+}
+
+func (s *Simulator) threePhaseData(t time.Time) map[string]any {
+	iac := round2(s.Power(t) / 3 / gridVoltage)
+	data := make(map[string]any)
+	for _, phase := range []string{"L1", "L2", "L3"} {
+		data["IAC_"+phase] = value{"A", iac}
+		data["UAC_"+phase] = value{"V", gridVoltage}
+	}
+	return data
+}
+
+func (s *Simulator) serveArchive(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("DeviceClass") != "Inverter" {
+		s.writeResponse(w, r, CodeNotSupported, nil)
+		return
+	}
+	id, ok := s.inverterID(r)
+	if !ok {
+		s.writeResponse(w, r, CodeDeviceNotAvailable, nil)
+		return
+	}
+	start, err1 := time.ParseInLocation("02.01.2006", q.Get("StartDate"), s.cfg.Location)
+	end, err2 := time.ParseInLocation("02.01.2006", q.Get("EndDate"), s.cfg.Location)
+	if err1 != nil || err2 != nil || end.Before(start) {
+		http.Error(w, "invalid StartDate or EndDate", http.StatusBadRequest)
+		return
+	}
+	end = end.AddDate(0, 0, 1)
+	now, _ := s.now()
+	if end.After(now) {
+		end = now
+	}
+
+	type channel struct {
+		Unit   string             `json:"Unit"`
+		Values map[string]float64 `json:"Values"`
+	}
+	channels := make(map[string]*channel)
+	for _, name := range q["Channel"] {
+		if unit, ok := archiveUnits[name]; ok {
+			channels[name] = &channel{Unit: unit, Values: make(map[string]float64)}
+		}
+	}
+
+	switch q.Get("SeriesType") {
+	case "DailySum":
+		for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+			if ch, ok := channels["EnergyReal_WAC_Sum_Produced"]; ok {
+				dayEnd := day.AddDate(0, 0, 1).Add(-time.Second)
+				if dayEnd.After(end) {
+					dayEnd = end
+				}
+				offset := strconv.Itoa(int(day.Sub(start).Seconds()))
+				ch.Values[offset] = math.Round(s.DayEnergy(dayEnd))
+			}
+		}
+	case "Detail":
+		// The datalogger only archives while the inverter feeds in.
+		for t := start.Add(archiveStep); !t.After(end); t = t.Add(archiveStep) {
+			if s.Power(t) == 0 && s.Power(t.Add(-archiveStep)) == 0 {
+				continue
+			}
+			offset := strconv.Itoa(int(t.Sub(start).Seconds()))
+			for name, ch := range channels {
+				ch.Values[offset] = s.archiveValue(name, t)
+			}
+		}
+	default:
+		s.writeResponse(w, r, CodeNotSupported, nil)
+		return
+	}
+
+	data := map[string]any{
+		"Start": start.Format(time.RFC3339),
+		"End":   end.Add(-time.Second).Format(time.RFC3339),
+		"Data":  channels,
+	}
+	s.writeResponse(w, r, CodeOK, map[string]any{"inverter/" + strconv.Itoa(id): data})
+}
+
+// archiveUnits lists the archive channels the simulator records and their
+// units.
+var archiveUnits = map[string]string{
+	"EnergyReal_WAC_Sum_Produced": "Wh",
+	"PowerReal_PAC_Sum":           "W",
+	"Voltage_AC_Phase_1":          "V",
+	"Voltage_AC_Phase_2":          "V",
+	"Voltage_AC_Phase_3":          "V",
+	"Current_AC_Phase_1":          "A",
+	"Current_AC_Phase_2":          "A",
+	"Current_AC_Phase_3":          "A",
+	"Voltage_DC_String_1":         "V",
+	"Current_DC_String_1":         "A",
+	"Voltage_DC_String_2":         "V",
+	"Current_DC_String_2":         "A",
+}
+
+// archiveValue returns the value of an archive channel for the interval
+// ending at t.
+func (s *Simulator) archiveValue(name string, t time.Time) float64 {
+	pac := s.Power(t)
+	phases := 1.0
+	if s.cfg.ThreePhase {
+		phases = 3
+	}
+	switch name {
+	case "EnergyReal_WAC_Sum_Produced":
+		return round2(s.DayEnergy(t) - s.DayEnergy(t.Add(-archiveStep)))
+	case "PowerReal_PAC_Sum":
+		return math.Round(pac)
+	case "Voltage_AC_Phase_1", "Voltage_AC_Phase_2", "Voltage_AC_Phase_3":
+		return gridVoltage
+	case "Current_AC_Phase_1", "Current_AC_Phase_2", "Current_AC_Phase_3":
+		return round2(pac / phases / gridVoltage)
+	case "Voltage_DC_String_1", "Voltage_DC_String_2":
+		return dcVoltage
+	default: // DC string currents
+		return round2(pac / efficiency / dcVoltage / float64(s.cfg.Trackers))
+	}
+}
+
+// grid returns the power drawn from the grid at t, W; negative when feeding
+// in.
+func (s *Simulator) grid(t time.Time) float64 {
+	return s.cfg.Load - float64(s.cfg.Inverters)*s.Power(t)
+}
+
+func (s *Simulator) serveMeter(w http.ResponseWriter, r *http.Request) {
+	if code := s.realtimeCode(); code != CodeOK {
+		s.writeResponse(w, r, code, nil)
+		return
+	}
+	if !s.cfg.Meter || r.URL.Query().Get("DeviceId") != "0" {
+		s.writeResponse(w, r, CodeDeviceNotAvailable, nil)
+		return
+	}
+	t, _ := s.now()
+	p := s.grid(t)
+	// The counters assume the same daily balance every day since the
+	// installation, which is enough for them to rise plausibly.
+	days := s.totalEnergy(t) / s.fullDayEnergy()
+	data := map[string]any{
+		"PowerReal_P_Sum":               math.Round(p),
+		"Frequency_Phase_Average":       gridFrequency,
+		"EnergyReal_WAC_Plus_Absolute":  math.Round(days * s.cfg.Load * 10),
+		"EnergyReal_WAC_Minus_Absolute": math.Round(days * max(float64(s.cfg.Inverters)*s.fullDayEnergy()-s.cfg.Load*14, 0)),
+		"Meter_Location_Current":        0,
+		"Details": map[string]string{
+			"Manufacturer": "Fronius",
+			"Model":        "Smart Meter 63A (simulated)",
+			"Serial":       "SIMMETER0",
+		},
+	}
+	for i := 1; i <= 3; i++ {
+		n := strconv.Itoa(i)
+		data["Voltage_AC_Phase_"+n] = gridVoltage
+		data["Current_AC_Phase_"+n] = round2(math.Abs(p) / 3 / gridVoltage)
+		data["PowerReal_P_Phase_"+n] = round2(p / 3)
+	}
+	s.writeResponse(w, r, CodeOK, data)
+}
+
+func (s *Simulator) servePowerFlow(w http.ResponseWriter, r *http.Request) {
+	if code := s.realtimeCode(); code != CodeOK {
+		s.writeResponse(w, r, code, nil)
+		return
+	}
+	t, _ := s.now()
+	site := map[string]any{
+		"Mode":                "produce-only",
+		"Meter_Location":      "unknown",
+		"P_Grid":              nil,
+		"P_Load":              nil,
+		"P_Akku":              nil,
+		"P_PV":                nil,
+		"rel_Autonomy":        nil,
+		"rel_SelfConsumption": nil,
+		"E_Day":               0.0,
+		"E_Year":              0.0,
+		"E_Total":             0.0,
+	}
+	inverters := make(map[string]any)
+	var pv float64
+	for id := 1; id <= s.cfg.Inverters; id++ {
+		p := math.Round(s.Power(t))
+		pv += p
+		inverters[strconv.Itoa(id)] = map[string]any{
+			"DT":      1,
+			"P":       p,
+			"E_Day":   math.Round(s.DayEnergy(t)),
+			"E_Year":  math.Round(s.yearEnergy(t)),
+			"E_Total": math.Round(s.totalEnergy(t)),
+		}
+		site["E_Day"] = site["E_Day"].(float64) + math.Round(s.DayEnergy(t))
+		site["E_Year"] = site["E_Year"].(float64) + math.Round(s.yearEnergy(t))
+		site["E_Total"] = site["E_Total"].(float64) + math.Round(s.totalEnergy(t))
+	}
+	if pv > 0 {
+		site["P_PV"] = pv
+	}
+	if s.cfg.Meter {
+		grid := math.Round(s.grid(t))
+		site["Mode"] = "meter"
+		site["Meter_Location"] = "grid"
+		site["P_Grid"] = grid
+		site["P_Load"] = -s.cfg.Load
+		site["rel_Autonomy"] = math.Round(100 * min(pv/s.cfg.Load, 1))
+		if pv > 0 {
+			site["rel_SelfConsumption"] = math.Round(100 * min(s.cfg.Load/pv, 1))
+		}
+	}
+	if s.cfg.Storage {
+		site["Mode"] = "bidirectional"
+		site["P_Akku"] = 0.0
+	}
+	s.writeResponse(w, r, CodeOK, map[string]any{"Site": site, "Inverters": inverters})
+}
+
+func (s *Simulator) serveStorage(w http.ResponseWriter, r *http.Request) {
+	if code := s.realtimeCode(); code != CodeOK {
+		s.writeResponse(w, r, code, nil)
+		return
+	}
+	if !s.cfg.Storage || r.URL.Query().Get("DeviceId") != "0" {
+		s.writeResponse(w, r, CodeDeviceNotAvailable, nil)
+		return
+	}
+	t, _ := s.now()
+	// The battery charges through the day and discharges overnight.
+	x := min(max(s.dayFraction(t), 0), 1)
+	soc := 20 + 75*(1-math.Cos(math.Pi*x))/2
+	s.writeResponse(w, r, CodeOK, map[string]any{
+		"Controller": map[string]any{
+			"Enable":                 1,
+			"StateOfCharge_Relative": math.Round(soc*10) / 10,
+			"Current_DC":             0.0,
+			"Voltage_DC":             52.0,
+			"Temperature_Cell":       24.5,
+			"Capacity_Maximum":       10000,
+			"DesignedCapacity":       10000,
+			"StatusBatteryCell":      3,
+			"Details": map[string]string{
+				"Manufacturer": "BYD",
+				"Model":        "BYD Battery-Box (simulated)",
+				"Serial":       "SIMBATT0",
+			},
+		},
+		"Modules": []map[string]any{{
+			"Temperature_Cell_Maximum": 25.5,
+			"Temperature_Cell_Minimum": 23.5,
+		}},
+	})
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
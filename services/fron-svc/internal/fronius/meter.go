@@ -0,0 +1,86 @@
+package fronius
+
+import (
+	"context"
+	"fmt"
+)
+
+// MeterData holds fields from GetMeterRealtimeData for a Fronius Smart Meter.
+// Per-phase values are nil on single-phase meters; all values are nil when the
+// meter does not report them.
+type MeterData struct {
+	VoltageL1 *float64 `json:"Voltage_AC_Phase_1"`
+	VoltageL2 *float64 `json:"Voltage_AC_Phase_2"`
+	VoltageL3 *float64 `json:"Voltage_AC_Phase_3"`
+	CurrentL1 *float64 `json:"Current_AC_Phase_1"`
+	CurrentL2 *float64 `json:"Current_AC_Phase_2"`
+	CurrentL3 *float64 `json:"Current_AC_Phase_3"`
+
+	PowerRealL1  *float64 `json:"PowerReal_P_Phase_1"`
+	PowerRealL2  *float64 `json:"PowerReal_P_Phase_2"`
+	PowerRealL3  *float64 `json:"PowerReal_P_Phase_3"`
+	PowerRealSum *float64 `json:"PowerReal_P_Sum"`
+
+	PowerReactiveL1  *float64 `json:"PowerReactive_Q_Phase_1"`
+	PowerReactiveL2  *float64 `json:"PowerReactive_Q_Phase_2"`
+	PowerReactiveL3  *float64 `json:"PowerReactive_Q_Phase_3"`
+	PowerReactiveSum *float64 `json:"PowerReactive_Q_Sum"`
+
+	PowerApparentL1  *float64 `json:"PowerApparent_S_Phase_1"`
+	PowerApparentL2  *float64 `json:"PowerApparent_S_Phase_2"`
+	PowerApparentL3  *float64 `json:"PowerApparent_S_Phase_3"`
+	PowerApparentSum *float64 `json:"PowerApparent_S_Sum"`
+
+	PowerFactorL1  *float64 `json:"PowerFactor_Phase_1"`
+	PowerFactorL2  *float64 `json:"PowerFactor_Phase_2"`
+	PowerFactorL3  *float64 `json:"PowerFactor_Phase_3"`
+	PowerFactorSum *float64 `json:"PowerFactor_Sum"`
+
+	Frequency *float64 `json:"Frequency_Phase_Average"`
+
+	// EnergyImport and EnergyExport are absolute counters (Wh) of energy drawn
+	// from and fed into the grid, independent of the meter's location setting.
+	EnergyImport *float64 `json:"EnergyReal_WAC_Plus_Absolute"`
+	EnergyExport *float64 `json:"EnergyReal_WAC_Minus_Absolute"`
+
+	// MeterLocation is 0 at the grid feed-in point, 1 in the consumption path.
+	MeterLocation *float64 `json:"Meter_Location_Current"`
+
+	Details struct {
+		Manufacturer string `json:"Manufacturer"`
+		Model        string `json:"Model"`
+		Serial       string `json:"Serial"`
+	} `json:"Details"`
+}
+
+// MeterResponse is the full envelope from GetMeterRealtimeData.
+type MeterResponse struct {
+	Body struct {
+		Data MeterData `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+		// Timestamp is the datalogger's clock when it answered, as in
+		// RealtimeDataResponse.
+		Timestamp string `json:"Timestamp"`
+	} `json:"Head"`
+}
+
+// FetchMeter retrieves realtime readings from the smart meter with the given
+// DeviceId (usually 0).
+func (c *Client) FetchMeter(ctx context.Context, deviceID int) (*MeterResponse, error) {
+	url := fmt.Sprintf("%s/solar_api/v1/GetMeterRealtimeData.cgi?Scope=Device&DeviceId=%d", c.baseURL, deviceID)
+
+	var result MeterResponse
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,71 @@
+package fronius
+
+import "context"
+
+// PowerFlowSite holds site-level power flows from GetPowerFlowRealtimeData.
+// Power values follow the Fronius sign convention: P_Grid is positive when
+// importing and negative when exporting, P_Load is negative when consuming, and
+// P_Akku is positive when discharging and negative when charging. Values are nil
+// when the component is absent (e.g. no battery or no meter).
+type PowerFlowSite struct {
+	Mode               string   `json:"Mode"`
+	MeterLocation      string   `json:"Meter_Location"`
+	PGrid              *float64 `json:"P_Grid"`
+	PLoad              *float64 `json:"P_Load"`
+	PAkku              *float64 `json:"P_Akku"`
+	PPV                *float64 `json:"P_PV"`
+	RelAutonomy        *float64 `json:"rel_Autonomy"`
+	RelSelfConsumption *float64 `json:"rel_SelfConsumption"`
+	EDay               *float64 `json:"E_Day"`
+	EYear              *float64 `json:"E_Year"`
+	ETotal             *float64 `json:"E_Total"`
+}
+
+// PowerFlowInverter holds one inverter's share of the power flow.
+type PowerFlowInverter struct {
+	DT     int      `json:"DT"`
+	P      *float64 `json:"P"`
+	SOC    *float64 `json:"SOC"`
+	EDay   *float64 `json:"E_Day"`
+	EYear  *float64 `json:"E_Year"`
+	ETotal *float64 `json:"E_Total"`
+}
+
+// PowerFlowData holds the site and per-inverter flows from GetPowerFlowRealtimeData.
+type PowerFlowData struct {
+	Site      PowerFlowSite                `json:"Site"`
+	Inverters map[string]PowerFlowInverter `json:"Inverters"`
+}
+
+// PowerFlowResponse is the full envelope from GetPowerFlowRealtimeData.
+type PowerFlowResponse struct {
+	Body struct {
+		Data PowerFlowData `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+		// Timestamp is the datalogger's clock when it answered, as in
+		// RealtimeDataResponse.
+		Timestamp string `json:"Timestamp"`
+	} `json:"Head"`
+}
+
+// FetchPowerFlow retrieves site-level power flow from the datalogger. The Solar
+// API serves this endpoint as .fcgi rather than .cgi. It is only meaningful on
+// hybrid or metered installs; on a plain inverter most site fields are nil.
+func (c *Client) FetchPowerFlow(ctx context.Context) (*PowerFlowResponse, error) {
+	url := c.baseURL + "/solar_api/v1/GetPowerFlowRealtimeData.fcgi"
+
+	var result PowerFlowResponse
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
+	}
+
+	return &result, nil
+}
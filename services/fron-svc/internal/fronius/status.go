@@ -0,0 +1,159 @@
+package fronius
+
+import "fmt"
+
+// State is the coarse operating state derived from an inverter's StatusCode
+// and ErrorCode.
+type State string
+
+const (
+	StateStartup State = "startup"
+	StateRunning State = "running"
+	StateStandby State = "standby"
+	StateFault   State = "fault"
+	StateUnknown State = "unknown"
+)
+
+// statusCodes describes DeviceStatus.StatusCode values as documented in the
+// Solar API v1 specification. Codes 0-6 are all startup phases.
+var statusCodes = map[int]struct {
+	description string
+	state       State
+}{
+	0:   {"Startup", StateStartup},
+	1:   {"Startup", StateStartup},
+	2:   {"Startup", StateStartup},
+	3:   {"Startup", StateStartup},
+	4:   {"Startup", StateStartup},
+	5:   {"Startup", StateStartup},
+	6:   {"Startup", StateStartup},
+	7:   {"Running", StateRunning},
+	8:   {"Standby", StateStandby},
+	9:   {"Bootloading", StateStartup},
+	10:  {"Error", StateFault},
+	11:  {"Idle", StateStandby},
+	12:  {"Ready", StateStartup},
+	13:  {"Sleeping", StateStandby},
+	255: {"Unknown", StateUnknown},
+}
+
+// errorCodes describes common DeviceStatus.ErrorCode values (the state codes
+// shown on the inverter display). Codes not listed here are reported by number.
+var errorCodes = map[int]string{
+	102: "AC voltage too high",
+	103: "AC voltage too low",
+	105: "AC frequency too high",
+	106: "AC frequency too low",
+	107: "AC grid outside permissible limits",
+	108: "Islanding detected",
+	112: "RCMU error",
+	240: "Arc fault detected",
+	241: "Arc fault detected",
+	301: "AC overcurrent",
+	302: "DC overcurrent",
+	303: "Power stage overtemperature",
+	304: "Internal temperature too high",
+	305: "No power fed in despite closed relay",
+	306: "Insufficient PV power for feeding in",
+	307: "DC input voltage too low for feeding in",
+	308: "Intermediate circuit voltage too high",
+	309: "DC input voltage MPPT 1 too high",
+	313: "DC input voltage MPPT 2 too high",
+	401: "No communication with power stage",
+	406: "Power stage temperature sensor faulty",
+	407: "Internal temperature sensor faulty",
+	408: "DC component in grid too high",
+	412: "Fixed voltage selected instead of MPP voltage, and fixed voltage too low or too high",
+	415: "Emergency stop triggered",
+	416: "No communication between power stage and control system",
+	417: "Hardware ID problem",
+	419: "Unique ID conflict",
+	425: "No communication with power stage",
+	426: "Possible hardware fault",
+	427: "Possible hardware fault",
+	428: "Possible hardware fault",
+	431: "Software problem",
+	436: "Functional incompatibility",
+	437: "Power stage set problem",
+	438: "Functional incompatibility",
+	443: "Intermediate circuit voltage too low or asymmetric",
+	445: "Invalid power stage configuration",
+	447: "Insulation fault",
+	448: "Neutral conductor not connected",
+	450: "Guard cannot be found",
+	451: "Memory error detected",
+	452: "Communication error between processors",
+	453: "Grid voltage and power stage are incompatible",
+	454: "Grid frequency and power stage are incompatible",
+	456: "Anti-islanding function not working correctly",
+	457: "Grid relay sticking",
+	463: "AC polarity reversed",
+	474: "RCMU sensor faulty",
+	475: "Insulation fault between solar modules and ground",
+	476: "Driver supply voltage too low",
+	482: "Setup after initial start-up interrupted",
+	489: "Permanent overvoltage on intermediate circuit capacitor",
+	502: "Insulation error on the solar modules",
+	509: "No energy fed in within the last 24 hours",
+	515: "No communication with filter",
+	516: "No communication with storage unit",
+	517: "Power derating caused by high temperature",
+	560: "Power derating caused by overfrequency",
+	566: "Arc detector switched off",
+	567: "Grid voltage dependent power reduction active",
+}
+
+// benignErrorCodes are error codes an inverter reports as a matter of course
+// while the sun is too low to feed in, at every dusk and dawn. They do not
+// make a non-running inverter faulty.
+var benignErrorCodes = map[int]bool{
+	306: true,
+	307: true,
+}
+
+// Status interprets a DeviceStatus.
+type Status struct {
+	StatusCode  int
+	ErrorCode   int
+	State       State
+	Description string // StatusCode description, e.g. "Standby"
+	Error       string // ErrorCode description, empty when ErrorCode is 0
+}
+
+// Describe looks up s in the status and error code catalogues. A non-running
+// inverter reporting an error code is in StateFault, since that error is why
+// it is not feeding in, unless the code is one of the benignErrorCodes.
+func (s DeviceStatus) Describe() Status {
+	st := Status{
+		StatusCode:  s.StatusCode,
+		ErrorCode:   s.ErrorCode,
+		State:       StateUnknown,
+		Description: fmt.Sprintf("Status code %d", s.StatusCode),
+	}
+	if c, ok := statusCodes[s.StatusCode]; ok {
+		st.State = c.state
+		st.Description = c.description
+	}
+	if s.ErrorCode != 0 {
+		st.Error = ErrorDescription(s.ErrorCode)
+		if st.State != StateRunning && !benignErrorCodes[s.ErrorCode] {
+			st.State = StateFault
+		}
+	}
+	return st
+}
+
+// ErrorDescription returns a human-readable description of an error code.
+func ErrorDescription(code int) string {
+	if d, ok := errorCodes[code]; ok {
+		return d
+	}
+	return fmt.Sprintf("Error code %d", code)
+}
+
+func (s Status) String() string {
+	if s.ErrorCode != 0 {
+		return fmt.Sprintf("%s (status %d, error %d: %s)", s.State, s.StatusCode, s.ErrorCode, s.Error)
+	}
+	return fmt.Sprintf("%s (status %d: %s)", s.State, s.StatusCode, s.Description)
+}
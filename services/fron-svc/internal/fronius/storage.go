@@ -0,0 +1,69 @@
+package fronius
+
+import (
+	"context"
+	"fmt"
+)
+
+// StorageController holds battery controller fields from GetStorageRealtimeData.
+// Values are nil when the battery does not report them.
+type StorageController struct {
+	Enable            *float64 `json:"Enable"`
+	StateOfCharge     *float64 `json:"StateOfCharge_Relative"`
+	CurrentDC         *float64 `json:"Current_DC"`
+	VoltageDC         *float64 `json:"Voltage_DC"`
+	TemperatureCell   *float64 `json:"Temperature_Cell"`
+	CapacityMaximum   *float64 `json:"Capacity_Maximum"`
+	DesignedCapacity  *float64 `json:"DesignedCapacity"`
+	StatusBatteryCell *float64 `json:"StatusBatteryCell"`
+
+	Details struct {
+		Manufacturer string `json:"Manufacturer"`
+		Model        string `json:"Model"`
+		Serial       string `json:"Serial"`
+	} `json:"Details"`
+}
+
+// StorageModule holds per-module cell temperatures, reported by some batteries.
+type StorageModule struct {
+	TemperatureCellMaximum *float64 `json:"Temperature_Cell_Maximum"`
+	TemperatureCellMinimum *float64 `json:"Temperature_Cell_Minimum"`
+}
+
+// StorageData holds the controller and module data for one storage device.
+type StorageData struct {
+	Controller StorageController `json:"Controller"`
+	Modules    []StorageModule   `json:"Modules"`
+}
+
+// StorageResponse is the full envelope from GetStorageRealtimeData.
+type StorageResponse struct {
+	Body struct {
+		Data StorageData `json:"Data"`
+	} `json:"Body"`
+	Head struct {
+		Status struct {
+			Code int `json:"Code"`
+		} `json:"Status"`
+		// Timestamp is the datalogger's clock when it answered, as in
+		// RealtimeDataResponse.
+		Timestamp string `json:"Timestamp"`
+	} `json:"Head"`
+}
+
+// FetchStorage retrieves realtime battery data from the storage device with
+// the given DeviceId (usually 0).
+func (c *Client) FetchStorage(ctx context.Context, deviceID int) (*StorageResponse, error) {
+	url := fmt.Sprintf("%s/solar_api/v1/GetStorageRealtimeData.cgi?Scope=Device&DeviceId=%d", c.baseURL, deviceID)
+
+	var result StorageResponse
+	if err := c.getJSON(ctx, c.httpClient, url, &result); err != nil {
+		return nil, err
+	}
+
+	if result.Head.Status.Code != 0 {
+		return nil, &APIError{Code: result.Head.Status.Code}
+	}
+
+	return &result, nil
+}
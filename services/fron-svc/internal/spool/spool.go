@@ -0,0 +1,223 @@
+// Package spool implements a bounded on-disk FIFO queue of text lines.
+//
+// Lines are appended to numbered segment files in a directory. Segments are
+// read back oldest first and removed once their lines have been delivered, so
+// the queue survives restarts. When the queue grows past its limit the oldest
+// segments are discarded.
+package spool
+
+import (
+	"bufio"
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentSuffix is the file extension of segment files.
+const segmentSuffix = ".spool"
+
+// maxSegmentBytes is the size at which the tail segment is sealed and a new
+// one started. It also bounds how much is replayed in one go.
+const maxSegmentBytes = 1 << 20
+
+type segment struct {
+	seq  uint64
+	size int64
+}
+
+// Spool is a bounded on-disk FIFO of lines. It is safe for concurrent use.
+type Spool struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []segment // oldest first; the last one is the tail
+	tail     *os.File  // open tail segment, nil until the next append
+	size     int64
+}
+
+// Segment is a sealed segment read back from the spool.
+type Segment struct {
+	Seq   uint64
+	Lines []string
+}
+
+// Open opens the spool in dir, creating dir if needed. Segments left by a
+// previous run are kept and will be returned by Oldest.
+func Open(dir string, maxBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxBytes: maxBytes}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentSuffix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		s.segments = append(s.segments, segment{seq: seq, size: info.Size()})
+		s.size += info.Size()
+	}
+	slices.SortFunc(s.segments, func(a, b segment) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+	return s, nil
+}
+
+// Size returns the number of bytes queued.
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Append adds lines to the end of the queue. If that takes the queue past its
+// limit, the oldest segments are discarded and the number of bytes dropped is
+// returned.
+func (s *Spool) Append(lines []string) (dropped int64, err error) {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tail == nil || s.segments[len(s.segments)-1].size >= maxSegmentBytes {
+		if err := s.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.tail.WriteString(b.String())
+	s.segments[len(s.segments)-1].size += int64(n)
+	s.size += int64(n)
+	if err != nil {
+		return 0, err
+	}
+
+	// Never discard the tail, which holds the lines just written.
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		oldest := s.segments[0]
+		if err := os.Remove(s.path(oldest.seq)); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+		s.segments = s.segments[1:]
+		s.size -= oldest.size
+		dropped += oldest.size
+	}
+	return dropped, nil
+}
+
+// Oldest returns the oldest segment, or nil if the queue is empty. If the
+// oldest segment is the tail it is sealed first, so later appends go to a new
+// segment. The segment stays queued until Remove is called.
+func (s *Spool) Oldest() (*Segment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		return nil, nil
+	}
+	oldest := s.segments[0]
+	if len(s.segments) == 1 && s.tail != nil {
+		if err := s.tail.Close(); err != nil {
+			return nil, err
+		}
+		s.tail = nil
+	}
+
+	f, err := os.Open(s.path(oldest.seq))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seg := &Segment{Seq: oldest.seq}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, maxSegmentBytes)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			seg.Lines = append(seg.Lines, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("spool: read segment %d: %w", oldest.seq, err)
+	}
+	return seg, nil
+}
+
+// Remove deletes a segment returned by Oldest once its lines are delivered.
+// Removing a segment that was already discarded is not an error.
+func (s *Spool) Remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.segments, func(seg segment) bool { return seg.seq == seq })
+	if i < 0 {
+		return nil
+	}
+	if i == len(s.segments)-1 && s.tail != nil {
+		return fmt.Errorf("spool: segment %d is still open", seq)
+	}
+	if err := os.Remove(s.path(seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.size -= s.segments[i].size
+	s.segments = slices.Delete(s.segments, i, i+1)
+	return nil
+}
+
+// Close closes the tail segment. Queued lines stay on disk.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tail == nil {
+		return nil
+	}
+	err := s.tail.Close()
+	s.tail = nil
+	return err
+}
+
+// rotate closes the tail segment, if any, and starts a new one.
+func (s *Spool) rotate() error {
+	if s.tail != nil {
+		if err := s.tail.Close(); err != nil {
+			return err
+		}
+		s.tail = nil
+	}
+	var seq uint64 = 1
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	f, err := os.OpenFile(s.path(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.tail = f
+	s.segments = append(s.segments, segment{seq: seq})
+	return nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
@@ -0,0 +1,159 @@
+package spool
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fullLine is a line that, with its newline, fills a segment on its own.
+var fullLine = strings.Repeat("x", maxSegmentBytes-1)
+
+func open(t *testing.T, dir string, maxBytes int64) *Spool {
+	t.Helper()
+	s, err := Open(dir, maxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func appendLines(t *testing.T, s *Spool, lines ...string) int64 {
+	t.Helper()
+	dropped, err := s.Append(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dropped
+}
+
+func oldest(t *testing.T, s *Spool) *Segment {
+	t.Helper()
+	seg, err := s.Oldest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return seg
+}
+
+func TestRollover(t *testing.T) {
+	s := open(t, t.TempDir(), 10*maxSegmentBytes)
+
+	appendLines(t, s, fullLine)
+	appendLines(t, s, "a", "b")
+	if got, want := s.Size(), int64(maxSegmentBytes+4); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+
+	seg := oldest(t, s)
+	if seg.Seq != 1 || len(seg.Lines) != 1 || seg.Lines[0] != fullLine {
+		t.Fatalf("oldest = segment %d with %d line(s), want segment 1 with the full line", seg.Seq, len(seg.Lines))
+	}
+	if err := s.Remove(seg.Seq); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lines after the full segment went to a new one.
+	seg = oldest(t, s)
+	if seg.Seq != 2 || !slices.Equal(seg.Lines, []string{"a", "b"}) {
+		t.Errorf("oldest = segment %d %q, want segment 2 [a b]", seg.Seq, seg.Lines)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	s := open(t, t.TempDir(), 2*maxSegmentBytes+maxSegmentBytes/2)
+
+	for range 2 {
+		if dropped := appendLines(t, s, fullLine); dropped != 0 {
+			t.Fatalf("dropped %d bytes under the limit", dropped)
+		}
+	}
+	if dropped := appendLines(t, s, fullLine); dropped != maxSegmentBytes {
+		t.Errorf("dropped %d bytes past the limit, want the oldest segment's %d", dropped, maxSegmentBytes)
+	}
+	if got, want := s.Size(), int64(2*maxSegmentBytes); got != want {
+		t.Errorf("Size = %d, want %d", got, want)
+	}
+	if seg := oldest(t, s); seg.Seq != 2 {
+		t.Errorf("oldest = segment %d, want 2", seg.Seq)
+	}
+
+	// The tail is never dropped, even on its own past the limit.
+	s = open(t, t.TempDir(), 10)
+	appendLines(t, s, "a line longer than the limit")
+	if seg := oldest(t, s); seg == nil || len(seg.Lines) != 1 {
+		t.Errorf("oldest = %+v, want the line just written", seg)
+	}
+}
+
+func TestOldestSealsTail(t *testing.T) {
+	s := open(t, t.TempDir(), maxSegmentBytes)
+
+	if seg := oldest(t, s); seg != nil {
+		t.Fatalf("oldest of an empty spool = %+v, want nil", seg)
+	}
+
+	appendLines(t, s, "a")
+	seg := oldest(t, s)
+	if seg.Seq != 1 || !slices.Equal(seg.Lines, []string{"a"}) {
+		t.Fatalf("oldest = segment %d %q, want segment 1 [a]", seg.Seq, seg.Lines)
+	}
+
+	// Sealed, so later lines go to a new segment and do not end up in the one
+	// being delivered.
+	appendLines(t, s, "b")
+	if err := s.Remove(2); err == nil {
+		t.Error("removed the open tail segment")
+	}
+	if err := s.Remove(seg.Seq); err != nil {
+		t.Fatal(err)
+	}
+	seg = oldest(t, s)
+	if seg.Seq != 2 || !slices.Equal(seg.Lines, []string{"b"}) {
+		t.Errorf("oldest = segment %d %q, want segment 2 [b]", seg.Seq, seg.Lines)
+	}
+	if err := s.Remove(seg.Seq); err != nil {
+		t.Fatal(err)
+	}
+	if s.Size() != 0 {
+		t.Errorf("Size = %d after removing everything, want 0", s.Size())
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, 10*maxSegmentBytes)
+	appendLines(t, s, fullLine)
+	appendLines(t, s, "a")
+	size := s.Size()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir, 10*maxSegmentBytes)
+	if s.Size() != size {
+		t.Errorf("Size after reopening = %d, want %d", s.Size(), size)
+	}
+
+	// Appends after a restart start a new segment rather than reuse one
+	// left behind.
+	appendLines(t, s, "b")
+	var got []uint64
+	for {
+		seg := oldest(t, s)
+		if seg == nil {
+			break
+		}
+		got = append(got, seg.Seq)
+		if seg.Seq == 3 && !slices.Equal(seg.Lines, []string{"b"}) {
+			t.Errorf("segment 3 = %q, want [b]", seg.Lines)
+		}
+		if err := s.Remove(seg.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []uint64{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("segments replayed %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// streamBuffer is how many readings a slow /api/stream client may fall
+	// behind before readings are dropped for it.
+	streamBuffer = 64
+
+	// streamKeepAlive is how often an idle stream sends a comment, so proxies
+	// do not close it.
+	streamKeepAlive = 30 * time.Second
+)
+
+// liveReading is the JSON form of a reading.
+type liveReading struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Fields      map[string]interface{} `json:"fields"`
+	Time        time.Time              `json:"time"`
+}
+
+func newLiveReading(r Reading) liveReading {
+	return liveReading{Measurement: r.Measurement, Tags: r.Tags, Fields: r.Fields, Time: r.Time}
+}
+
+// liveSink keeps the latest reading of every series and a running summary of
+// the local day, and pushes readings to /api/stream clients, so displays can
+// follow the installation without querying InfluxDB. It is always enabled.
+type liveSink struct {
+	mu      sync.Mutex
+	current map[string]Reading // by series key
+	day     time.Time          // start of the day summarised
+	today   map[string]*rollup // by series key
+	subs    map[chan []byte]bool
+}
+
+func newLiveSink() *liveSink {
+	return &liveSink{
+		current: make(map[string]Reading),
+		day:     startOfDay(time.Now()),
+		today:   make(map[string]*rollup),
+		subs:    make(map[chan []byte]bool),
+	}
+}
+
+func (s *liveSink) Name() string { return "live" }
+
+func (s *liveSink) Write(ctx context.Context, readings ...Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if day := startOfDay(time.Now()); day.After(s.day) {
+		s.day = day
+		s.today = make(map[string]*rollup)
+	}
+	for _, r := range readings {
+		key := seriesKey(r.Measurement, r.Tags)
+
+		// Events are pushed to the stream but are not a current value.
+		if r.Measurement != "inverter_event" {
+			if !r.Time.Before(s.day) {
+				a, ok := s.today[key]
+				if !ok {
+					a = newRollup(s.day)
+					s.today[key] = a
+				}
+				a.add(r)
+			}
+			if prev, ok := s.current[key]; ok && r.Time.Before(prev.Time) {
+				continue // a backfilled reading is older than what we have
+			}
+			s.current[key] = r
+		}
+
+		b, err := json.Marshal(newLiveReading(r))
+		if err != nil {
+			continue
+		}
+		for ch := range s.subs {
+			select {
+			case ch <- b:
+			default: // the client is not keeping up
+			}
+		}
+	}
+	return nil
+}
+
+func (s *liveSink) Close() error { return nil }
+
+func (s *liveSink) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/current", s.serveCurrent)
+	mux.HandleFunc("GET /api/today", s.serveToday)
+	mux.HandleFunc("GET /api/stream", s.serveStream)
+}
+
+// serveCurrent returns the latest reading of every series, ordered by
+// measurement and tags.
+func (s *liveSink) serveCurrent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	readings := make([]liveReading, 0, len(s.current))
+	for _, key := range slices.Sorted(maps.Keys(s.current)) {
+		readings = append(readings, newLiveReading(s.current[key]))
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Readings []liveReading `json:"readings"`
+	}{readings})
+}
+
+// serveToday returns the rollup of every series since local midnight: means of
+// instantaneous fields, latest energy counters and the peak of pac.
+func (s *liveSink) serveToday(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	day := s.day
+	summary := make([]liveReading, 0, len(s.today))
+	for _, key := range slices.Sorted(maps.Keys(s.today)) {
+		a := s.today[key]
+		rd := a.reading(s.current[key].Measurement)
+		rd.Time = a.lastT
+		summary = append(summary, newLiveReading(rd))
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Date   string        `json:"date"`
+		Series []liveReading `json:"series"`
+	}{day.Format(time.DateOnly), summary})
+}
+
+// serveStream sends each new reading as a Server-Sent Event named "reading"
+// until the client disconnects.
+func (s *liveSink) serveStream(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ch := make(chan []byte, streamBuffer)
+	s.mu.Lock()
+	s.subs[ch] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subs, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(streamKeepAlive)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case b := <-ch:
+			_, err = fmt.Fprintf(w, "event: reading\ndata: %s\n\n", b)
+		case <-ticker.C:
+			_, err = fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
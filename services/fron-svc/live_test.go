@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func liveInverter(t time.Time, pac, dayEnergy float64) Reading {
+	return Reading{
+		Measurement: "inverter",
+		Tags:        map[string]string{"device_id": "roof"},
+		Fields:      map[string]interface{}{"pac": pac, "day_energy": dayEnergy},
+		Time:        t,
+	}
+}
+
+// getJSON serves path from s and decodes the response into v.
+func getJSON(t *testing.T, s *liveSink, path string, v any) {
+	t.Helper()
+	mux := http.NewServeMux()
+	s.register(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", path, rec.Code)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("%s: %v", path, err)
+	}
+}
+
+func TestLiveSink(t *testing.T) {
+	s := newLiveSink()
+	now := time.Now()
+	today := startOfDay(now)
+	// Three times since midnight, oldest first.
+	t0, t1, t2 := today.Add(now.Sub(today)/4), today.Add(now.Sub(today)/2), now
+
+	err := s.Write(context.Background(),
+		liveInverter(t1, 1000, 2000),
+		liveInverter(t2, 3000, 4000),
+		Reading{
+			Measurement: "inverter_event",
+			Tags:        map[string]string{"device_id": "roof"},
+			Fields:      map[string]interface{}{"state": "running"},
+			Time:        t2,
+		},
+		// Backfilled: older than the current reading, and from yesterday.
+		liveInverter(t0, 500, 1000),
+		liveInverter(today.Add(-time.Hour), 9000, 30000),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var current struct {
+		Readings []liveReading `json:"readings"`
+	}
+	getJSON(t, s, "/api/current", &current)
+	if len(current.Readings) != 1 {
+		t.Fatalf("got %d current readings, want the inverter's only: %+v", len(current.Readings), current.Readings)
+	}
+	r := current.Readings[0]
+	if r.Measurement != "inverter" || r.Tags["device_id"] != "roof" || !r.Time.Equal(t2) {
+		t.Errorf("current = %s %v at %s, want the roof inverter at %s", r.Measurement, r.Tags, r.Time, t2)
+	}
+	if r.Fields["pac"] != 3000.0 {
+		t.Errorf("current pac = %v, want 3000: a backfilled reading replaced it", r.Fields["pac"])
+	}
+
+	var summary struct {
+		Date   string        `json:"date"`
+		Series []liveReading `json:"series"`
+	}
+	getJSON(t, s, "/api/today", &summary)
+	if summary.Date != today.Format(time.DateOnly) {
+		t.Errorf("date = %s, want %s", summary.Date, today.Format(time.DateOnly))
+	}
+	if len(summary.Series) != 1 {
+		t.Fatalf("got %d series today, want 1", len(summary.Series))
+	}
+	day := summary.Series[0]
+	if !day.Time.Equal(t2) {
+		t.Errorf("today stamped %s, want the latest reading's time %s", day.Time, t2)
+	}
+	// Today's backfilled reading counts towards the summary; yesterday's
+	// does not.
+	for field, want := range map[string]float64{
+		"pac":        1500,
+		"pac_min":    500,
+		"pac_max":    3000,
+		"day_energy": 4000,
+		"samples":    3,
+	} {
+		if got := day.Fields[field]; got != want {
+			t.Errorf("today %s = %v, want %v", field, got, want)
+		}
+	}
+}
+
+func TestLiveStream(t *testing.T) {
+	s := newLiveSink()
+	mux := http.NewServeMux()
+	s.register(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	now := time.Now()
+	if err := s.Write(context.Background(), liveInverter(now, 1000, 2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	var event, data string
+	for sc.Scan() && sc.Text() != "" {
+		if v, ok := strings.CutPrefix(sc.Text(), "event: "); ok {
+			event = v
+		}
+		if v, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			data = v
+		}
+	}
+	if event != "reading" {
+		t.Errorf("event = %q, want reading", event)
+	}
+	var r liveReading
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatalf("data %q: %v", data, err)
+	}
+	if r.Measurement != "inverter" || !r.Time.Equal(now) || r.Fields["pac"] != 1000.0 {
+		t.Errorf("streamed %+v, want the reading written", r)
+	}
+}
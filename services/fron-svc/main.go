@@ -1,158 +1,257 @@
 package main
 
 import (
+	"cmp"
 	"context"
 	"log"
+	"maps"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"slices"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
 	"go.local/pkg/env"
+	"go.local/pkg/introspect"
 	"go.local/services/fron-svc/internal/fronius"
 )
 
 const (
-	deviceID        = "fronius"
 	healthAddr      = ":8082"
 	pollInterval    = 5 * time.Second
 	backoffMax      = 10 * time.Minute
 	archiveInterval = 24 * time.Hour
+
+	defaultStorageInterval = 30 * time.Second
+	defaultSpoolDir        = "spool"
+	defaultAuthCacheTTL    = 30 * time.Second
 )
 
 func main() {
-	inverterURL := env.Required("INVERTER_URL")
-	capacityW, err := strconv.ParseFloat(env.Required("INVERTER_CAPACITY_W"), 64)
-	if err != nil {
-		log.Fatalf("Invalid INVERTER_CAPACITY_W: %v", err)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "backfill":
+			backfillCommand(os.Args[2:])
+			return
+		case "init":
+			initCommand(os.Args[2:])
+			return
+		case "simulate":
+			simulateCommand(os.Args[2:])
+			return
+		}
 	}
 
-	influxURL    := env.Required("INFLUX_URL")
-	influxToken  := env.Required("INFLUX_TOKEN")
-	influxOrg    := env.Required("INFLUX_ORG")
-	influxBucket := env.Required("INFLUX_BUCKET")
-
-	froniusClient := fronius.New(inverterURL, &http.Client{Timeout: 4 * time.Second})
+	inverters, err := loadInverters()
+	if err != nil {
+		log.Fatalf("Invalid inverter configuration: %v", err)
+	}
+	capacities, err := loadInverterCapacities()
+	if err != nil {
+		log.Fatalf("Invalid inverter configuration: %v", err)
+	}
+	meters, err := loadDevices("METERS")
+	if err != nil {
+		log.Fatalf("Invalid meter configuration: %v", err)
+	}
+	storage, err := loadDevices("STORAGE")
+	if err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	storageInterval := env.Duration("STORAGE_POLL_INTERVAL", defaultStorageInterval)
 
-	influxClient := influxdb2.NewClient(influxURL, influxToken)
-	defer influxClient.Close()
-	writeAPI := influxClient.WriteAPIBlocking(influxOrg, influxBucket)
+	names, err := sinkNames()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Println("Configuration:")
-	log.Printf("  INVERTER_URL        = %s", inverterURL)
-	log.Printf("  INVERTER_CAPACITY_W = %.0f", capacityW)
-	log.Printf("  INFLUX_URL          = %s", influxURL)
-	log.Printf("  INFLUX_ORG          = %s", influxOrg)
-	log.Printf("  INFLUX_BUCKET       = %s", influxBucket)
-	log.Println()
+	powerFlow := env.Bool("POWER_FLOW")
+	discovery := os.Getenv("DISCOVERY") == "" || env.Bool("DISCOVERY")
+	tariff, err := loadTariff()
+	if err != nil {
+		log.Fatal(err)
+	}
+	timeSource := cmp.Or(os.Getenv("TIME_SOURCE"), "poller")
+	if timeSource != "poller" && timeSource != "inverter" {
+		log.Fatalf("Invalid TIME_SOURCE: must be poller or inverter, got %q", timeSource)
+	}
 
-	go func() {
-		mux := http.NewServeMux()
-		mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-		})
-		log.Printf("Health check listening on %s", healthAddr)
-		if err := http.ListenAndServe(healthAddr, mux); err != nil {
-			log.Fatalf("Health check server failed: %v", err)
-		}
-	}()
+	spoolDir := cmp.Or(os.Getenv("SPOOL_DIR"), defaultSpoolDir)
+	if err := os.MkdirAll(spoolDir, 0o755); err != nil {
+		log.Fatalf("Failed to create SPOOL_DIR: %v", err)
+	}
+	cps, err := loadCheckpoints(checkpointsPath(spoolDir))
+	if err != nil {
+		log.Fatalf("Failed to load checkpoints: %v", err)
+	}
+	backfillMaxDays := env.Int("BACKFILL_MAX_DAYS", defaultBackfillMaxDays)
 
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
-	interval := pollInterval
-	timer := time.NewTimer(0) // fire immediately on start
-	defer timer.Stop()
-
-	archiveTimer := time.NewTimer(0) // fire immediately on start
-	defer archiveTimer.Stop()
-
-	var offline bool
-	var cachedMonthEnergy float64
+	// Devices behind the same datalogger share one client.
+	clients := make(map[string]*fronius.Client)
+	for _, url := range dataloggerURLs(inverters, meters, storage) {
+		clients[url] = fronius.New(url, &http.Client{Timeout: 4 * time.Second})
+	}
+	urls := slices.Sorted(maps.Keys(clients))
 
-	log.Printf("Polling inverter every %s (backoff max %s)", pollInterval, backoffMax)
-	log.Printf("Polling archive every %s", archiveInterval)
+	var topo topology
+	if discovery {
+		topo.Dataloggers = discoverAll(ctx, urls, clients)
+		logTopology(topo.Dataloggers)
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Println("Shutting down")
-			return
-		case <-archiveTimer.C:
-			if wh, err := froniusClient.FetchMonthEnergy(ctx, time.Now()); err != nil {
-				if ctx.Err() == nil {
-					log.Printf("Archive fetch failed: %v", err)
-				}
-			} else {
-				cachedMonthEnergy = wh
+		var found []dataloggerTopology
+		for _, t := range topo.Dataloggers {
+			if t.Error == "" {
+				found = append(found, t)
 			}
-			if ctx.Err() == nil {
-				archiveTimer.Reset(archiveInterval)
-			}
-		case <-timer.C:
-			err := poll(ctx, froniusClient, writeAPI, deviceID, capacityW, cachedMonthEnergy)
-			if ctx.Err() != nil {
-				return
-			}
-			if err != nil {
-				if !offline {
-					log.Printf("Inverter unreachable: %v", err)
-					offline = true
-				}
-				interval = min(interval*2, backoffMax)
-				log.Printf("Retrying in %s", interval)
-			} else {
-				if offline {
-					log.Println("Inverter back online, resuming normal polling")
-					offline = false
-				}
-				interval = pollInterval
-			}
-			timer.Reset(interval)
+		}
+
+		// Discovered devices replace defaults, never explicit configuration:
+		// INVERTER_URL's DeviceId=1, and unset METERS, STORAGE and POWER_FLOW.
+		if os.Getenv("INVERTERS") == "" && len(found) == 1 && len(found[0].Inverters) > 0 {
+			inverters = discoveredInverters(found[0], inverters[0], capacities)
+		}
+		if os.Getenv("METERS") == "" {
+			meters = discoveredDevices(found, "meter", func(t dataloggerTopology) []discoveredDevice { return t.Meters })
+		}
+		if os.Getenv("STORAGE") == "" {
+			storage = discoveredDevices(found, "storage", func(t dataloggerTopology) []discoveredDevice { return t.Storage })
+		}
+		if os.Getenv("POWER_FLOW") == "" {
+			powerFlow = len(meters) > 0 || len(storage) > 0
 		}
 	}
-}
 
-func poll(ctx context.Context, client *fronius.Client, writeAPI api.WriteAPIBlocking, deviceID string, capacityW float64, monthEnergyWh float64) error {
-	data, err := client.Fetch(ctx)
-	if err != nil {
-		return err
+	log.Println("Configuration:")
+	for _, inv := range inverters {
+		log.Printf("  INVERTER            = %s (%s, DeviceId %d, %.0f W)", inv.Name, inv.URL, inv.DeviceID, inv.CapacityW)
+	}
+	for _, m := range meters {
+		log.Printf("  METER               = %s (%s, DeviceId %d)", m.Name, m.URL, m.DeviceID)
+	}
+	for _, b := range storage {
+		log.Printf("  STORAGE             = %s (%s, DeviceId %d, every %s)", b.Name, b.URL, b.DeviceID, storageInterval)
+	}
+	log.Printf("  BACKFILL_MAX_DAYS   = %d", backfillMaxDays)
+	log.Printf("  POWER_FLOW          = %t", powerFlow)
+	log.Printf("  DISCOVERY           = %t", discovery)
+	log.Printf("  TIME_SOURCE         = %s", timeSource)
+	log.Printf("  SINKS               = %s", strings.Join(names, ","))
+	if tariff != nil {
+		log.Printf("  TARIFF              = feed-in %s, import %s, supply charge %g/day", tariff.FeedIn, tariff.Import, tariff.SupplyCharge)
+	}
+	var auth *introspect.Client
+	if authURL := os.Getenv("AUTH_INTROSPECT_URL"); authURL != "" {
+		ttl := env.Duration("AUTH_CACHE_TTL", defaultAuthCacheTTL)
+		log.Printf("  AUTH_INTROSPECT_URL = %s (cached %s)", authURL, ttl)
+		auth = introspect.New(authURL, ttl)
 	}
 
-	if data.Body.Data.DeviceStatus.StatusCode != fronius.StatusRunning {
-		return nil
+	// Sinks log their own settings as they open.
+	h := newHealth()
+	sinks, err := openSinks(names, spoolDir, h)
+	if err != nil {
+		log.Fatalf("Failed to open sinks: %v", err)
+	}
+	// The live API on the health port is always available.
+	multi := newMultiSink(append(sinks, newLiveSink()))
+	defer multi.Close()
+	// Earnings are derived from live readings on their way to every sink;
+	// the catch-up writes past readings straight to the sinks.
+	var sink Sink = multi
+	if tariff != nil {
+		var meterNames []string
+		for _, m := range meters {
+			meterNames = append(meterNames, m.Name)
+		}
+		sink = newEarningsSink(*tariff, meterNames, cps, multi)
+	}
+	var ds *downsampler
+	if slices.Contains(names, "influxdb") {
+		ds = newDownsampler(loadInfluxConfig(), cps, h, backfillMaxDays)
 	}
+	log.Println()
+
+	log.Printf("Polling %d inverter(s) every %s (backoff max %s)", len(inverters), pollInterval, backoffMax)
+	log.Printf("Polling archive every %s", archiveInterval)
 
-	d := data.Body.Data
-	pacW := d.PAC.Value
+	var wg sync.WaitGroup
+	wg.Go(func() { multi.run(ctx) })
+	wg.Go(func() { cps.run(ctx) })
+	startedAt := time.Now()
+	inverterTime := timeSource == "inverter"
+	var catchUps sync.WaitGroup
+	for _, inv := range inverters {
+		since := cps.get(inv.Name)
+		catchUps.Go(func() { catchUp(ctx, inv, clients[inv.URL], multi, since, startedAt, backfillMaxDays) })
 
-	fields := map[string]interface{}{
-		"pac":          pacW,
-		"iac":          d.IAC.Value,
-		"uac":          d.UAC.Value,
-		"fac":          d.FAC.Value,
-		"idc":          d.IDC.Value,
-		"udc":          d.UDC.Value,
-		"day_energy":   d.DayEnergy.Value,
-		"year_energy":  d.YearEnergy.Value,
-		"total_energy": d.TotalEnergy.Value,
-		"pac_kw":       pacW / 1000,
-		"utilisation":  (pacW / capacityW) * 100,
+		p := &inverterPoller{inv: inv, client: clients[inv.URL], sink: sink, health: h, checkpoints: cps}
+		p.clock = deviceClock{label: "[" + inv.Name + "] Inverter", inverterTime: inverterTime}
+		wg.Go(func() { p.run(ctx) })
+		topo.Pollers.Inverters = append(topo.Pollers.Inverters, inv.Name)
+	}
+	if ds != nil {
+		// Periods are aggregated once, so wait for the catch-up to fill them.
+		wg.Go(func() {
+			catchUps.Wait()
+			ds.run(ctx)
+		})
 	}
-	if monthEnergyWh > 0 {
-		fields["month_energy"] = monthEnergyWh
+	for _, m := range meters {
+		p := &meterPoller{meter: m, client: clients[m.URL], sink: sink, health: h}
+		p.clock = deviceClock{label: "[" + m.Name + "] Meter", inverterTime: inverterTime}
+		wg.Go(func() { p.run(ctx) })
+		topo.Pollers.Meters = append(topo.Pollers.Meters, m.Name)
+	}
+	if len(meters) > 0 {
+		log.Printf("Polling %d meter(s) every %s", len(meters), pollInterval)
+	}
+	for _, b := range storage {
+		p := &storagePoller{storage: b, interval: storageInterval, client: clients[b.URL], sink: sink, health: h}
+		p.clock = deviceClock{label: "[" + b.Name + "] Storage", inverterTime: inverterTime}
+		wg.Go(func() { p.run(ctx) })
+		topo.Pollers.Storage = append(topo.Pollers.Storage, b.Name)
+	}
+	if len(storage) > 0 {
+		log.Printf("Polling %d storage device(s) every %s", len(storage), storageInterval)
+	}
+	if powerFlow {
+		for _, url := range urls {
+			p := &powerFlowPoller{datalogger: dataloggerTag(url), client: clients[url], sink: sink, health: h}
+			p.clock = deviceClock{label: "[" + p.datalogger + "] Power flow", inverterTime: inverterTime}
+			wg.Go(func() { p.run(ctx) })
+			topo.Pollers.PowerFlow = append(topo.Pollers.PowerFlow, p.datalogger)
+		}
+		log.Printf("Polling power flow from %d datalogger(s) every %s", len(urls), pollInterval)
 	}
 
-	p := influxdb2.NewPoint(
-		"inverter",
-		map[string]string{"device_id": deviceID},
-		fields,
-		time.Now(),
-	)
+	// Started once the pollers are known so /api/topology is complete.
+	go func() {
+		api := http.NewServeMux()
+		api.HandleFunc("GET /api/topology", serveTopology(&topo))
+		multi.register(api)
+
+		// Everything but /healthz needs a session or token when auth is on.
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /healthz", h.serveHealth)
+		if auth != nil {
+			mux.Handle("/", auth.Middleware(api))
+		} else {
+			mux.Handle("/", api)
+		}
+		log.Printf("Health check listening on %s", healthAddr)
+		if err := http.ListenAndServe(healthAddr, mux); err != nil {
+			log.Fatalf("Health check server failed: %v", err)
+		}
+	}()
 
-	return writeAPI.WritePoint(ctx, p)
+	<-ctx.Done()
+	log.Println("Shutting down")
+	wg.Wait()
+	catchUps.Wait()
 }
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"go.local/pkg/env"
+)
+
+const (
+	// mqttKeepAlive is the MQTT keepalive; the broker publishes the will after
+	// 1.5 times this without a packet.
+	mqttKeepAlive = 30 * time.Second
+
+	// mqttPingTimeout is how long a keepalive ping may go unanswered before
+	// the connection is treated as lost.
+	mqttPingTimeout = 10 * time.Second
+
+	// mqttDialTimeout bounds each connection attempt.
+	mqttDialTimeout = 5 * time.Second
+
+	// mqttWriteTimeout bounds each publish.
+	mqttWriteTimeout = 10 * time.Second
+
+	// mqttMaxAge is how old a reading may be and still be published. MQTT
+	// carries live state, so backfilled readings are skipped.
+	mqttMaxAge = 5 * time.Minute
+
+	// availabilityInterval is how often device availability is re-checked.
+	availabilityInterval = 5 * time.Second
+
+	// mqttQueue is how many writes may wait to be published before further
+	// readings are dropped.
+	mqttQueue = 64
+
+	defaultMQTTTopicPrefix     = "fronius"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTClientID        = "fron-svc"
+)
+
+var (
+	errMQTTNotConnected = errors.New("mqtt: not connected")
+	errMQTTQueueFull    = errors.New("mqtt: publish queue full, dropping readings")
+	errMQTTTimeout      = errors.New("mqtt: broker not responding")
+)
+
+// mqttConfig holds the MQTT connection and topic settings.
+type mqttConfig struct {
+	URL             string
+	Username        string
+	Password        string
+	ClientID        string
+	TopicPrefix     string
+	DiscoveryPrefix string
+	Discovery       bool
+}
+
+// loadMQTTConfig reads the MQTT_* variables. MQTT_URL is required when the
+// mqtt sink is enabled. MQTT_USERNAME and MQTT_PASSWORD override credentials
+// in the URL.
+func loadMQTTConfig() mqttConfig {
+	return mqttConfig{
+		URL:             env.Required("MQTT_URL"),
+		Username:        os.Getenv("MQTT_USERNAME"),
+		Password:        os.Getenv("MQTT_PASSWORD"),
+		ClientID:        cmp.Or(os.Getenv("MQTT_CLIENT_ID"), defaultMQTTClientID),
+		TopicPrefix:     strings.TrimSuffix(cmp.Or(os.Getenv("MQTT_TOPIC_PREFIX"), defaultMQTTTopicPrefix), "/"),
+		DiscoveryPrefix: strings.TrimSuffix(cmp.Or(os.Getenv("MQTT_DISCOVERY_PREFIX"), defaultMQTTDiscoveryPrefix), "/"),
+		Discovery:       os.Getenv("MQTT_DISCOVERY") == "" || env.Bool("MQTT_DISCOVERY"),
+	}
+}
+
+// mqttSink publishes each reading as a JSON state topic and one topic per
+// field, with retained Home Assistant discovery configs and availability
+// topics for the service and every device.
+//
+// Write only queues readings; run publishes them, so a slow or unreachable
+// broker never holds up the other sinks. Publishes are QoS 0; readings taken
+// while the broker is unreachable, or while the queue is full, are lost,
+// which suits live state. Reconnects are made here rather than by the client
+// library, so they back off up to backoffMax like those of the other sinks.
+type mqttSink struct {
+	cfg    mqttConfig
+	opts   *paho.ClientOptions
+	broker string // host:port, for logging
+	health *health
+	queue  chan []Reading
+
+	mu        sync.Mutex
+	client    paho.Client // nil until connected
+	nextDial  time.Time
+	backoff   time.Duration
+	failing   bool              // the last publish failed
+	announced map[string]bool   // discovery configs published on this connection
+	available map[string]string // availability published on this connection, by health key
+}
+
+func newMQTTSink(cfg mqttConfig, h *health) (*mqttSink, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MQTT_URL: %w", err)
+	}
+	username, password := cfg.Username, cfg.Password
+	if u.User != nil {
+		urlPassword, _ := u.User.Password()
+		username = cmp.Or(username, u.User.Username())
+		password = cmp.Or(password, urlPassword)
+	}
+	// MQTT 3.1.1 §3.1.2.9: a password may only be sent with a user name.
+	if password != "" && username == "" {
+		return nil, errors.New("a password is set without a user name; set MQTT_USERNAME or a user in MQTT_URL")
+	}
+
+	s := &mqttSink{cfg: cfg, broker: u.Host, health: h, queue: make(chan []Reading, mqttQueue)}
+	s.opts = paho.NewClientOptions().
+		SetClientID(cfg.ClientID).
+		SetUsername(username).
+		SetPassword(password).
+		SetCleanSession(true).
+		SetKeepAlive(mqttKeepAlive).
+		SetPingTimeout(mqttPingTimeout).
+		SetConnectTimeout(mqttDialTimeout).
+		SetWriteTimeout(mqttWriteTimeout).
+		SetAutoReconnect(false).
+		SetBinaryWill(s.statusTopic(), []byte("offline"), 0, true).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if u.Port() == "" {
+			s.broker += ":1883"
+		}
+		s.opts.AddBroker("tcp://" + s.broker)
+	case "mqtts", "ssl", "tls":
+		if u.Port() == "" {
+			s.broker += ":8883"
+		}
+		s.opts.AddBroker("ssl://" + s.broker)
+		s.opts.SetTLSConfig(&tls.Config{ServerName: u.Hostname()})
+	default:
+		return nil, fmt.Errorf("invalid MQTT_URL: scheme must be mqtt or mqtts, got %q", u.Scheme)
+	}
+
+	log.Printf("  MQTT_URL            = %s", u.Redacted())
+	log.Printf("  MQTT_TOPIC_PREFIX   = %s", cfg.TopicPrefix)
+	if cfg.Discovery {
+		log.Printf("  MQTT_DISCOVERY      = %s", cfg.DiscoveryPrefix)
+	}
+	h.setSink(s.Name(), errMQTTNotConnected, stateDown)
+	return s, nil
+}
+
+func (s *mqttSink) Name() string { return "mqtt" }
+
+func (s *mqttSink) Write(ctx context.Context, readings ...Reading) error {
+	var live []Reading
+	for _, r := range readings {
+		if time.Since(r.Time) <= mqttMaxAge {
+			live = append(live, r)
+		}
+	}
+	if len(live) == 0 {
+		return nil
+	}
+	select {
+	case s.queue <- live:
+		return nil
+	default:
+		return errMQTTQueueFull
+	}
+}
+
+func (s *mqttSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	// Disconnecting cleanly suppresses the will, so say so ourselves.
+	s.send(s.statusTopic(), []byte("offline"), true)
+	s.client.Disconnect(1000) // milliseconds to finish sending
+	return nil
+}
+
+// run publishes queued readings and keeps device availability topics in
+// step with /healthz.
+func (s *mqttSink) run(ctx context.Context) {
+	ticker := time.NewTicker(availabilityInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case readings := <-s.queue:
+			s.mu.Lock()
+			s.publish(ctx, readings)
+			s.mu.Unlock()
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.connect(ctx); err == nil {
+				s.publishAvailability()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// publish publishes readings, connecting first if needed. Failures are logged
+// when they start and stop, like those of any other sink. s.mu must be held.
+func (s *mqttSink) publish(ctx context.Context, readings []Reading) {
+	err := s.connect(ctx)
+	if err == nil {
+		for _, r := range readings {
+			if time.Since(r.Time) > mqttMaxAge {
+				continue // queued too long
+			}
+			if err = s.publishReading(r); err != nil {
+				s.health.setSink(s.Name(), err, stateDown)
+				break
+			}
+		}
+	}
+	switch {
+	case err != nil && !s.failing:
+		log.Printf("Publishing to MQTT failed, dropping readings until it recovers: %v", err)
+		s.failing = true
+	case err == nil && s.failing:
+		log.Printf("Publishing to MQTT recovered")
+		s.failing = false
+	}
+}
+
+// connect (re)connects if needed, respecting the reconnect backoff. On a new
+// connection it announces the service online and republishes availability;
+// discovery configs are republished as readings arrive. s.mu must be held.
+func (s *mqttSink) connect(ctx context.Context) error {
+	if s.client != nil {
+		if s.client.IsConnectionOpen() {
+			return nil
+		}
+		s.client = nil // the loss was logged by the connection lost handler
+	}
+	if time.Now().Before(s.nextDial) {
+		return errMQTTNotConnected
+	}
+
+	client := paho.NewClient(s.opts)
+	if err := wait(ctx, client.Connect(), mqttDialTimeout); err != nil {
+		client.Disconnect(0)
+		s.backoff = min(max(s.backoff*2, pollInterval), backoffMax)
+		s.nextDial = time.Now().Add(s.backoff)
+		s.health.setSink(s.Name(), err, stateDown)
+		return err
+	}
+	log.Printf("MQTT connected to %s", s.broker)
+	s.client = client
+	s.backoff = 0
+	s.announced = make(map[string]bool)
+	s.available = make(map[string]string)
+	s.health.setSink(s.Name(), nil, stateDown)
+
+	if err := s.send(s.statusTopic(), []byte("online"), true); err != nil {
+		return err
+	}
+	s.publishAvailability()
+	return nil
+}
+
+// send publishes payload at QoS 0 and waits for it to be written. s.mu must
+// be held and s.client set.
+func (s *mqttSink) send(topic string, payload []byte, retain bool) error {
+	return wait(context.Background(), s.client.Publish(topic, 0, retain, payload), mqttWriteTimeout)
+}
+
+// wait waits up to timeout for tok to complete, or until ctx is cancelled,
+// and returns its error.
+func wait(ctx context.Context, tok paho.Token, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-tok.Done():
+		return tok.Error()
+	case <-timer.C:
+		return errMQTTTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// publishAvailability publishes each device's online/offline state when it
+// changed. s.mu must be held and s.client set.
+func (s *mqttSink) publishAvailability() {
+	for key, d := range s.health.deviceStates() {
+		state := "offline"
+		if d.State == stateOK {
+			state = "online"
+		}
+		if s.available[key] == state {
+			continue
+		}
+		if err := s.send(s.availabilityTopic(key), []byte(state), true); err != nil {
+			return
+		}
+		s.available[key] = state
+	}
+}
+
+func (s *mqttSink) publishReading(r Reading) error {
+	kind, name := readingDevice(r)
+	base := s.cfg.TopicPrefix + "/" + r.Measurement + "/" + topicSegment(name)
+
+	state := make(map[string]interface{}, len(r.Fields)+1)
+	for k, v := range r.Fields {
+		state[k] = v
+	}
+	state["time"] = r.Time.UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := s.send(base, payload, false); err != nil {
+		return err
+	}
+
+	for field, v := range r.Fields {
+		if err := s.send(base+"/"+field, []byte(fmt.Sprint(v)), false); err != nil {
+			return err
+		}
+		if s.cfg.Discovery && r.Measurement != "inverter_event" {
+			if err := s.announce(r.Measurement, kind, name, field, v, base); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// haDevice is the device block of a Home Assistant discovery config.
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model,omitempty"`
+}
+
+type haAvailability struct {
+	Topic string `json:"topic"`
+}
+
+// haSensor is a Home Assistant MQTT sensor discovery config.
+type haSensor struct {
+	Name              string           `json:"name"`
+	UniqueID          string           `json:"unique_id"`
+	ObjectID          string           `json:"object_id"`
+	StateTopic        string           `json:"state_topic"`
+	ValueTemplate     string           `json:"value_template"`
+	UnitOfMeasurement string           `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string           `json:"device_class,omitempty"`
+	StateClass        string           `json:"state_class,omitempty"`
+	Availability      []haAvailability `json:"availability"`
+	AvailabilityMode  string           `json:"availability_mode"`
+	Device            haDevice         `json:"device"`
+}
+
+// announce publishes the retained discovery config for one field, once per
+// connection.
+func (s *mqttSink) announce(measurement, kind, name, field string, v interface{}, stateTopic string) error {
+	objectID := topicSegment(strings.Join([]string{"fron_svc", measurement, name, field}, "_"))
+	if s.announced[objectID] {
+		return nil
+	}
+
+	cfg := haSensor{
+		Name:          strings.ReplaceAll(strings.TrimPrefix(measurement+"_"+field, kind+"_"), "_", " "),
+		UniqueID:      objectID,
+		ObjectID:      objectID,
+		StateTopic:    stateTopic,
+		ValueTemplate: "{{ value_json." + field + " }}",
+		Availability: []haAvailability{
+			{Topic: s.statusTopic()},
+			{Topic: s.availabilityTopic(kind + "/" + name)},
+		},
+		AvailabilityMode: "all",
+		Device: haDevice{
+			Identifiers:  []string{topicSegment("fron_svc_" + kind + "_" + name)},
+			Name:         "Fronius " + strings.ReplaceAll(kind, "_", " ") + " " + name,
+			Manufacturer: "Fronius",
+		},
+	}
+	if _, numeric := toFloat(v); numeric {
+		cfg.UnitOfMeasurement, cfg.DeviceClass, cfg.StateClass = fieldMeta(field)
+	}
+
+	payload, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	topic := s.cfg.DiscoveryPrefix + "/sensor/" + objectID + "/config"
+	if err := s.send(topic, payload, true); err != nil {
+		return err
+	}
+	s.announced[objectID] = true
+	return nil
+}
+
+func (s *mqttSink) statusTopic() string {
+	return s.cfg.TopicPrefix + "/status"
+}
+
+// availabilityTopic is the online/offline topic for a health key such as
+// "inverter/roof".
+func (s *mqttSink) availabilityTopic(key string) string {
+	kind, name, _ := strings.Cut(key, "/")
+	return s.cfg.TopicPrefix + "/" + kind + "/" + topicSegment(name) + "/availability"
+}
+
+// readingDevice returns the health kind and name of the device a reading came
+// from, matching the keys of /healthz.
+func readingDevice(r Reading) (kind, name string) {
+	kind = r.Measurement
+	switch r.Measurement {
+	case "inverter_status", "inverter_event":
+		kind = "inverter"
+	}
+	name = cmp.Or(r.Tags["device_id"], r.Tags["datalogger"])
+	return kind, name
+}
+
+var topicUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// topicSegment makes s safe as a single topic level or discovery object ID.
+func topicSegment(s string) string {
+	return topicUnsafe.ReplaceAllString(s, "_")
+}
+
+// fieldMeta returns the Home Assistant unit, device class and state class
+// for a numeric field. Energy counters are total_increasing, which also
+// handles the daily and yearly resets of day_energy and year_energy.
+func fieldMeta(field string) (unit, deviceClass, stateClass string) {
+	switch {
+	case isEnergyField(field):
+		return "Wh", "energy", "total_increasing"
+	case field == "pac_kw":
+		return "kW", "power", "measurement"
+	case strings.HasPrefix(field, "reactive_power"):
+		return "var", "reactive_power", "measurement"
+	case strings.HasPrefix(field, "apparent_power"):
+		return "VA", "apparent_power", "measurement"
+	case strings.HasPrefix(field, "power_factor"):
+		return "", "power_factor", "measurement"
+	case field == "pac" || strings.HasPrefix(field, "pdc") || strings.HasPrefix(field, "power") || strings.HasPrefix(field, "p_"):
+		return "W", "power", "measurement"
+	case strings.HasPrefix(field, "iac") || strings.HasPrefix(field, "idc") || strings.HasPrefix(field, "current"):
+		return "A", "current", "measurement"
+	case strings.HasPrefix(field, "uac") || strings.HasPrefix(field, "udc") || strings.HasPrefix(field, "voltage"):
+		return "V", "voltage", "measurement"
+	case field == "fac" || field == "frequency":
+		return "Hz", "frequency", "measurement"
+	case field == "clock_drift":
+		return "s", "duration", "measurement"
+	case field == "soc":
+		return "%", "battery", "measurement"
+	case strings.HasPrefix(field, "temperature"):
+		return "°C", "temperature", "measurement"
+	case field == "utilisation" || field == "autonomy" || field == "self_consumption":
+		return "%", "", "measurement"
+	case field == "capacity_max" || field == "designed_capacity":
+		return "Wh", "", ""
+	default:
+		return "", "", ""
+	}
+}
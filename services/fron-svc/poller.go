@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+)
+
+// driftWarning is how far the inverter's clock may be from ours before it is
+// logged.
+const driftWarning = time.Minute
+
+// schedule calls fn immediately and then every interval until ctx is cancelled.
+// Consecutive failures double the wait, capped at backoffMax. The first failure
+// and the recovery are logged once each, prefixed with label.
+func schedule(ctx context.Context, label string, interval time.Duration, fn func(context.Context) error) {
+	wait := interval
+	timer := time.NewTimer(0) // fire immediately on start
+	defer timer.Stop()
+
+	var offline bool
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			err := fn(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				if !offline {
+					log.Printf("%s unreachable: %v", label, err)
+					offline = true
+				}
+				wait = min(wait*2, backoffMax)
+				log.Printf("%s retrying in %s", label, wait)
+			} else {
+				if offline {
+					log.Printf("%s back online, resuming normal polling", label)
+					offline = false
+				}
+				wait = interval
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+// inverterPoller polls one inverter on its own schedule, with its own backoff state.
+type inverterPoller struct {
+	inv    inverterConfig
+	client *fronius.Client
+	sink   Sink
+	health *health
+
+	mu            sync.Mutex
+	monthEnergyWh float64
+
+	// noThreePhase is set once the inverter rejects 3PInverterData, so
+	// single-phase inverters are not asked again.
+	noThreePhase bool
+
+	// state is the last observed operating state, empty before the first poll.
+	state fronius.State
+
+	clock       deviceClock
+	checkpoints *checkpoints
+}
+
+// run polls the inverter, and refreshes month energy from its archive, until
+// ctx is cancelled.
+func (p *inverterPoller) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Go(func() { p.refreshMonthEnergy(ctx) })
+	schedule(ctx, "["+p.inv.Name+"] Inverter", pollInterval, p.health.track("inverter/"+p.inv.Name, p.poll))
+	wg.Wait()
+}
+
+func (p *inverterPoller) refreshMonthEnergy(ctx context.Context) {
+	timer := time.NewTimer(0) // fire immediately on start
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			wh, err := p.client.FetchMonthEnergy(ctx, p.inv.DeviceID, time.Now())
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("[%s] Archive fetch failed: %v", p.inv.Name, err)
+			} else {
+				p.mu.Lock()
+				p.monthEnergyWh = wh
+				p.mu.Unlock()
+			}
+			timer.Reset(archiveInterval)
+		}
+	}
+}
+
+func (p *inverterPoller) poll(ctx context.Context) error {
+	sent := time.Now()
+	data, err := p.client.Fetch(ctx, p.inv.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	d := data.Body.Data
+	now, drift, ok := p.clock.timestamp(sent, time.Now(), data.Head.Timestamp)
+	st := d.DeviceStatus.Describe()
+	readings := p.statusReadings(st, now)
+	if ok {
+		readings[0].Fields["clock_drift"] = drift.Seconds()
+	}
+
+	// Outside running, the datalogger omits or zeroes the measurement values,
+	// so only the status is written.
+	if d.DeviceStatus.StatusCode != fronius.StatusRunning {
+		return p.writeStatus(ctx, st, readings)
+	}
+	pacW := d.PAC.Value
+
+	fields := map[string]interface{}{
+		"pac":          pacW,
+		"iac":          d.IAC.Value,
+		"uac":          d.UAC.Value,
+		"fac":          d.FAC.Value,
+		"idc":          d.IDC.Value,
+		"udc":          d.UDC.Value,
+		"day_energy":   d.DayEnergy.Value,
+		"year_energy":  d.YearEnergy.Value,
+		"total_energy": d.TotalEnergy.Value,
+		"pac_kw":       pacW / 1000,
+		"utilisation":  (pacW / p.inv.CapacityW) * 100,
+	}
+
+	// idc and udc are the first tracker only; with several trackers, write
+	// every tracker's DC input so a shaded or failing string stands out.
+	if inputs := d.DCInputs(); len(inputs) > 1 {
+		for i, in := range inputs {
+			n := strconv.Itoa(i + 1)
+			fields["idc_"+n] = in.Current.Value
+			fields["udc_"+n] = in.Voltage.Value
+			fields["pdc_"+n] = in.Current.Value * in.Voltage.Value
+		}
+	}
+
+	p.addThreePhase(ctx, fields)
+
+	p.mu.Lock()
+	monthEnergyWh := p.monthEnergyWh
+	p.mu.Unlock()
+	if monthEnergyWh > 0 {
+		fields["month_energy"] = monthEnergyWh
+	}
+
+	r := Reading{
+		Measurement: "inverter",
+		Tags:        map[string]string{"device_id": p.inv.Name},
+		Fields:      fields,
+		Time:        now,
+	}
+
+	return p.writeStatus(ctx, st, append(readings, r))
+}
+
+// deviceClock stamps one poller's readings, and watches the clock of the
+// datalogger it polls.
+type deviceClock struct {
+	label string // log prefix, as passed to schedule
+
+	// inverterTime stamps readings with the datalogger's Head.Timestamp
+	// instead of the poller's clock. drifting is set while the datalogger's
+	// clock is more than driftWarning out, timestampMissing once a missing
+	// timestamp has been logged, and zoneMismatch once a datalogger in a
+	// different zone from TZ has been logged.
+	inverterTime     bool
+	drifting         bool
+	timestampMissing bool
+	zoneMismatch     bool
+}
+
+// timestamp returns the time to stamp this poll's readings with and, if the
+// response carried a timestamp, how far the datalogger's clock is ahead of
+// ours. The poller's time is the midpoint of the request, which halves the
+// error a slow response would otherwise add. Drift beyond driftWarning is
+// logged once each way.
+func (c *deviceClock) timestamp(sent, received time.Time, ts string) (now time.Time, drift time.Duration, ok bool) {
+	polled := sent.Add(received.Sub(sent) / 2)
+	inverterTime, err := fronius.ParseTimestamp(ts, time.Local)
+	if err != nil {
+		if c.inverterTime && !c.timestampMissing {
+			log.Printf("%s sent no usable timestamp, using the poller's clock: %v", c.label, err)
+			c.timestampMissing = true
+		}
+		return polled, 0, false
+	}
+	c.timestampMissing = false
+
+	// Days (day energy, archives, CSV files, daily aggregates) are local
+	// days, so a TZ that disagrees with the datalogger splits them wrongly.
+	_, offset := inverterTime.Zone()
+	if _, local := inverterTime.In(time.Local).Zone(); offset != local && !c.zoneMismatch {
+		log.Printf("%s is at UTC%s but TZ gives UTC%s; set TZ to the datalogger's zone so days match",
+			c.label, inverterTime.Format("-07:00"), inverterTime.In(time.Local).Format("-07:00"))
+		c.zoneMismatch = true
+	}
+
+	drift = inverterTime.Sub(polled)
+	drifting := math.Abs(drift.Seconds()) > driftWarning.Seconds()
+	if drifting != c.drifting {
+		if drifting {
+			log.Printf("%s clock is %s out from ours", c.label, drift.Round(time.Second))
+		} else {
+			log.Printf("%s clock is back in step (%s out)", c.label, drift.Round(time.Second))
+		}
+		c.drifting = drifting
+	}
+
+	if c.inverterTime {
+		return inverterTime, drift, true
+	}
+	return polled, drift, true
+}
+
+// writeStatus writes readings and, once written, records st as the last
+// observed state so a failed write reports the transition again.
+func (p *inverterPoller) writeStatus(ctx context.Context, st fronius.Status, readings []Reading) error {
+	if err := p.sink.Write(ctx, readings...); err != nil {
+		return err
+	}
+	p.state = st.State
+	p.checkpoints.set(p.inv.Name, time.Now())
+	return nil
+}
+
+// statusReadings returns the inverter_status reading for this poll and, when
+// the operating state changed since the last poll, an inverter_event reading. The
+// transition is logged as well.
+func (p *inverterPoller) statusReadings(st fronius.Status, now time.Time) []Reading {
+	fields := map[string]interface{}{
+		"status_code": st.StatusCode,
+		"error_code":  st.ErrorCode,
+		"state":       string(st.State),
+		"description": st.Description,
+	}
+	if st.Error != "" {
+		fields["error"] = st.Error
+	}
+	readings := []Reading{{
+		Measurement: "inverter_status",
+		Tags:        map[string]string{"device_id": p.inv.Name},
+		Fields:      fields,
+		Time:        now,
+	}}
+
+	if st.State == p.state {
+		return readings
+	}
+
+	tags := map[string]string{"device_id": p.inv.Name, "to": string(st.State)}
+	if p.state == "" {
+		log.Printf("[%s] Inverter state: %s", p.inv.Name, st)
+	} else {
+		log.Printf("[%s] Inverter state changed from %s to %s", p.inv.Name, p.state, st)
+		tags["from"] = string(p.state)
+	}
+
+	fields = map[string]interface{}{
+		"status_code": st.StatusCode,
+		"error_code":  st.ErrorCode,
+		"message":     st.String(),
+	}
+	return append(readings, Reading{
+		Measurement: "inverter_event",
+		Tags:        tags,
+		Fields:      fields,
+		Time:        now,
+	})
+}
+
+// addThreePhase adds per-phase AC fields when the inverter supports
+// 3PInverterData. If the request fails for another reason the fields are
+// omitted from this poll only.
+func (p *inverterPoller) addThreePhase(ctx context.Context, fields map[string]interface{}) {
+	if p.noThreePhase {
+		return
+	}
+
+	data, err := p.client.FetchThreePhase(ctx, p.inv.DeviceID)
+	var apiErr *fronius.APIError
+	if errors.As(err, &apiErr) {
+		log.Printf("[%s] 3PInverterData not supported (%v), writing single-phase fields only", p.inv.Name, err)
+		p.noThreePhase = true
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	d := data.Body.Data
+	fields["iac_l1"] = d.IACL1.Value
+	fields["iac_l2"] = d.IACL2.Value
+	fields["iac_l3"] = d.IACL3.Value
+	fields["uac_l1"] = d.UACL1.Value
+	fields["uac_l2"] = d.UACL2.Value
+	fields["uac_l3"] = d.UACL3.Value
+}
+
+// powerFlowPoller polls site-level power flow from one datalogger.
+type powerFlowPoller struct {
+	datalogger string // datalogger tag, the host of its URL
+	client     *fronius.Client
+	sink       Sink
+	health     *health
+	clock      deviceClock
+}
+
+func (p *powerFlowPoller) run(ctx context.Context) {
+	schedule(ctx, "["+p.datalogger+"] Power flow", pollInterval, p.health.track("power_flow/"+p.datalogger, p.poll))
+}
+
+func (p *powerFlowPoller) poll(ctx context.Context) error {
+	sent := time.Now()
+	data, err := p.client.FetchPowerFlow(ctx)
+	if err != nil {
+		return err
+	}
+	now, _, _ := p.clock.timestamp(sent, time.Now(), data.Head.Timestamp)
+
+	site := data.Body.Data.Site
+	fields := map[string]interface{}{}
+	for name, v := range map[string]*float64{
+		"p_grid":           site.PGrid,
+		"p_load":           site.PLoad,
+		"p_battery":        site.PAkku,
+		"p_pv":             site.PPV,
+		"autonomy":         site.RelAutonomy,
+		"self_consumption": site.RelSelfConsumption,
+		"e_day":            site.EDay,
+		"e_year":           site.EYear,
+		"e_total":          site.ETotal,
+	} {
+		if v != nil {
+			fields[name] = *v
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	if site.Mode != "" {
+		fields["mode"] = site.Mode
+	}
+
+	r := Reading{
+		Measurement: "power_flow",
+		Tags:        map[string]string{"datalogger": p.datalogger},
+		Fields:      fields,
+		Time:        now,
+	}
+
+	return p.sink.Write(ctx, r)
+}
+
+// meterPoller polls one smart meter.
+type meterPoller struct {
+	meter  deviceConfig
+	client *fronius.Client
+	sink   Sink
+	health *health
+	clock  deviceClock
+}
+
+func (p *meterPoller) run(ctx context.Context) {
+	schedule(ctx, "["+p.meter.Name+"] Meter", pollInterval, p.health.track("meter/"+p.meter.Name, p.poll))
+}
+
+func (p *meterPoller) poll(ctx context.Context) error {
+	sent := time.Now()
+	data, err := p.client.FetchMeter(ctx, p.meter.DeviceID)
+	if err != nil {
+		return err
+	}
+	now, _, _ := p.clock.timestamp(sent, time.Now(), data.Head.Timestamp)
+
+	d := data.Body.Data
+	fields := map[string]interface{}{}
+	for name, v := range map[string]*float64{
+		"voltage_l1":        d.VoltageL1,
+		"voltage_l2":        d.VoltageL2,
+		"voltage_l3":        d.VoltageL3,
+		"current_l1":        d.CurrentL1,
+		"current_l2":        d.CurrentL2,
+		"current_l3":        d.CurrentL3,
+		"power_l1":          d.PowerRealL1,
+		"power_l2":          d.PowerRealL2,
+		"power_l3":          d.PowerRealL3,
+		"power":             d.PowerRealSum,
+		"reactive_power_l1": d.PowerReactiveL1,
+		"reactive_power_l2": d.PowerReactiveL2,
+		"reactive_power_l3": d.PowerReactiveL3,
+		"reactive_power":    d.PowerReactiveSum,
+		"apparent_power_l1": d.PowerApparentL1,
+		"apparent_power_l2": d.PowerApparentL2,
+		"apparent_power_l3": d.PowerApparentL3,
+		"apparent_power":    d.PowerApparentSum,
+		"power_factor_l1":   d.PowerFactorL1,
+		"power_factor_l2":   d.PowerFactorL2,
+		"power_factor_l3":   d.PowerFactorL3,
+		"power_factor":      d.PowerFactorSum,
+		"frequency":         d.Frequency,
+		"energy_import":     d.EnergyImport,
+		"energy_export":     d.EnergyExport,
+	} {
+		if v != nil {
+			fields[name] = *v
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	tags := map[string]string{"device_id": p.meter.Name}
+	if d.MeterLocation != nil {
+		tags["location"] = meterLocation(*d.MeterLocation)
+	}
+
+	r := Reading{
+		Measurement: "meter",
+		Tags:        tags,
+		Fields:      fields,
+		Time:        now,
+	}
+
+	return p.sink.Write(ctx, r)
+}
+
+// meterLocation names a Fronius Meter_Location_Current value.
+func meterLocation(v float64) string {
+	switch v {
+	case 0:
+		return "grid"
+	case 1:
+		return "load"
+	default:
+		return "other"
+	}
+}
+
+// storagePoller polls one battery on its own interval.
+type storagePoller struct {
+	storage  deviceConfig
+	interval time.Duration
+	client   *fronius.Client
+	sink     Sink
+	health   *health
+	clock    deviceClock
+}
+
+func (p *storagePoller) run(ctx context.Context) {
+	schedule(ctx, "["+p.storage.Name+"] Storage", p.interval, p.health.track("storage/"+p.storage.Name, p.poll))
+}
+
+func (p *storagePoller) poll(ctx context.Context) error {
+	sent := time.Now()
+	data, err := p.client.FetchStorage(ctx, p.storage.DeviceID)
+	if err != nil {
+		return err
+	}
+	now, _, _ := p.clock.timestamp(sent, time.Now(), data.Head.Timestamp)
+
+	c := data.Body.Data.Controller
+	fields := map[string]interface{}{}
+	for name, v := range map[string]*float64{
+		"soc":               c.StateOfCharge,
+		"current_dc":        c.CurrentDC,
+		"voltage_dc":        c.VoltageDC,
+		"temperature_cell":  c.TemperatureCell,
+		"capacity_max":      c.CapacityMaximum,
+		"designed_capacity": c.DesignedCapacity,
+		"status":            c.StatusBatteryCell,
+	} {
+		if v != nil {
+			fields[name] = *v
+		}
+	}
+	if c.CurrentDC != nil && c.VoltageDC != nil {
+		fields["power_dc"] = *c.CurrentDC * *c.VoltageDC
+	}
+
+	// Modules report their own cell temperature range; keep the extremes.
+	var cellMax, cellMin *float64
+	for _, m := range data.Body.Data.Modules {
+		if v := m.TemperatureCellMaximum; v != nil && (cellMax == nil || *v > *cellMax) {
+			cellMax = v
+		}
+		if v := m.TemperatureCellMinimum; v != nil && (cellMin == nil || *v < *cellMin) {
+			cellMin = v
+		}
+	}
+	if cellMax != nil {
+		fields["temperature_cell_max"] = *cellMax
+	}
+	if cellMin != nil {
+		fields["temperature_cell_min"] = *cellMin
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+
+	r := Reading{
+		Measurement: "storage",
+		Tags:        map[string]string{"device_id": p.storage.Name},
+		Fields:      fields,
+		Time:        now,
+	}
+
+	return p.sink.Write(ctx, r)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+	"go.local/services/fron-svc/internal/fronius/froniustest"
+)
+
+// recordSink keeps every reading written to it, or fails with err.
+type recordSink struct {
+	mu       sync.Mutex
+	readings []Reading
+	err      error
+}
+
+func (s *recordSink) Name() string { return "record" }
+
+func (s *recordSink) Write(ctx context.Context, readings ...Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.readings = append(s.readings, readings...)
+	return nil
+}
+
+func (s *recordSink) Close() error { return nil }
+
+// take returns the readings written since the last call, by measurement.
+func (s *recordSink) take() map[string][]Reading {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string][]Reading)
+	for _, r := range s.readings {
+		out[r.Measurement] = append(out[r.Measurement], r)
+	}
+	s.readings = nil
+	return out
+}
+
+// newTestPoller returns an inverter poller for DeviceId 1 of a simulator that
+// produces around the clock, writing to a recordSink.
+func newTestPoller(t *testing.T, cfg froniustest.Config, timeout time.Duration) (*inverterPoller, *froniustest.Simulator, *recordSink) {
+	t.Helper()
+	cfg.Sunrise, cfg.Sunset = time.Nanosecond, 24*time.Hour-time.Nanosecond
+	sim, srv := froniustest.NewServer(cfg)
+	t.Cleanup(srv.Close)
+
+	cps, err := loadCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := &recordSink{}
+	p := &inverterPoller{
+		inv:         inverterConfig{Name: "roof", URL: srv.URL, DeviceID: 1, CapacityW: 5000},
+		client:      fronius.New(srv.URL, &http.Client{Timeout: timeout}),
+		sink:        sink,
+		health:      newHealth(),
+		checkpoints: cps,
+	}
+	return p, sim, sink
+}
+
+// trackedPoll polls like run does, recording the result in p.health.
+func trackedPoll(ctx context.Context, p *inverterPoller) error {
+	return p.health.track("inverter/"+p.inv.Name, p.poll)(ctx)
+}
+
+func TestInverterPollerRunning(t *testing.T) {
+	p, sim, sink := newTestPoller(t, froniustest.Config{ThreePhase: true, Trackers: 2}, time.Second)
+	ctx := context.Background()
+
+	if err := trackedPoll(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	got := sink.take()
+	if len(got["inverter_status"]) != 1 || got["inverter_status"][0].Fields["state"] != string(fronius.StateRunning) {
+		t.Errorf("inverter_status = %+v, want one running reading", got["inverter_status"])
+	}
+	if len(got["inverter_event"]) != 1 {
+		t.Errorf("got %d events on the first poll, want 1", len(got["inverter_event"]))
+	}
+	if len(got["inverter"]) != 1 {
+		t.Fatalf("got %d inverter readings, want 1", len(got["inverter"]))
+	}
+	r := got["inverter"][0]
+	if r.Tags["device_id"] != "roof" {
+		t.Errorf("device_id = %q, want roof", r.Tags["device_id"])
+	}
+	pac, _ := toFloat(r.Fields["pac"])
+	if want := sim.Power(r.Time); math.Abs(pac-want) > 0.01*sim.Power(r.Time)+1 {
+		t.Errorf("pac = %v, want about %.0f", pac, want)
+	}
+	for _, field := range []string{"day_energy", "utilisation", "iac_l1", "uac_l3", "pdc_1", "pdc_2"} {
+		if _, ok := r.Fields[field]; !ok {
+			t.Errorf("field %s missing", field)
+		}
+	}
+	if st := p.health.deviceStates()["inverter/roof"]; st.State != stateOK {
+		t.Errorf("health = %s, want ok", st.State)
+	}
+	if p.checkpoints.get("roof").IsZero() {
+		t.Error("checkpoint not set after a successful poll")
+	}
+
+	// The state did not change, so there is no event this time.
+	if err := trackedPoll(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.take(); len(got["inverter_event"]) != 0 {
+		t.Errorf("got %d events without a state change", len(got["inverter_event"]))
+	}
+}
+
+func TestInverterPollerSinglePhase(t *testing.T) {
+	p, _, sink := newTestPoller(t, froniustest.Config{}, time.Second)
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !p.noThreePhase {
+		t.Error("3PInverterData rejected but not remembered")
+	}
+	r := sink.take()["inverter"][0]
+	if _, ok := r.Fields["iac_l1"]; ok {
+		t.Error("per-phase fields written for a single-phase inverter")
+	}
+	if _, ok := r.Fields["pdc_1"]; ok {
+		t.Error("per-tracker fields written for a single tracker")
+	}
+}
+
+func TestInverterPollerFault(t *testing.T) {
+	p, sim, sink := newTestPoller(t, froniustest.Config{}, time.Second)
+	ctx := context.Background()
+	if err := p.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sink.take()
+
+	sim.SetError(567)
+	if err := p.poll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got := sink.take()
+	if len(got["inverter"]) != 0 {
+		t.Error("measurements written while faulted")
+	}
+	events := got["inverter_event"]
+	if len(events) != 1 {
+		t.Fatalf("got %d events, want 1", len(events))
+	}
+	if from, to := events[0].Tags["from"], events[0].Tags["to"]; from != "running" || to != "fault" {
+		t.Errorf("event from %q to %q, want running to fault", from, to)
+	}
+	if code := events[0].Fields["error_code"]; code != 567 {
+		t.Errorf("error_code = %v, want 567", code)
+	}
+}
+
+func TestInverterPollerFailures(t *testing.T) {
+	p, sim, sink := newTestPoller(t, froniustest.Config{}, 100*time.Millisecond)
+	ctx := context.Background()
+
+	sim.SetAPIError(8)
+	var apiErr *fronius.APIError
+	if err := trackedPoll(ctx, p); !errors.As(err, &apiErr) {
+		t.Errorf("API error: err = %v, want *fronius.APIError", err)
+	}
+	if st := p.health.deviceStates()["inverter/roof"]; st.State != stateDown {
+		t.Errorf("health = %s, want down", st.State)
+	}
+	sim.SetAPIError(0)
+
+	sim.SetHang(true)
+	if err := trackedPoll(ctx, p); err == nil {
+		t.Error("hanging datalogger: want an error")
+	}
+	sim.SetHang(false)
+
+	if got := sink.take(); len(got) != 0 {
+		t.Errorf("readings written for failed polls: %v", got)
+	}
+	if !p.checkpoints.get("roof").IsZero() {
+		t.Error("checkpoint set without a successful poll")
+	}
+
+	// A failed write is a failed poll, and the state change is reported
+	// again once writes work.
+	sink.err = errors.New("sink down")
+	if err := trackedPoll(ctx, p); err == nil {
+		t.Error("failed write: want an error")
+	}
+	sink.err = nil
+	if err := trackedPoll(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	if got := sink.take(); len(got["inverter_event"]) != 1 {
+		t.Errorf("got %d events after the write recovered, want 1", len(got["inverter_event"]))
+	}
+	if st := p.health.deviceStates()["inverter/roof"]; st.State != stateOK {
+		t.Errorf("health = %s after recovery, want ok", st.State)
+	}
+}
+
+func TestInverterPollerClockDrift(t *testing.T) {
+	p, _, sink := newTestPoller(t, froniustest.Config{ClockOffset: 2 * time.Minute}, time.Second)
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status := sink.take()["inverter_status"][0]
+	if drift, _ := toFloat(status.Fields["clock_drift"]); math.Abs(drift-120) > 2 {
+		t.Errorf("clock_drift = %v, want about 120", drift)
+	}
+	if !p.clock.drifting {
+		t.Error("two minutes of drift not flagged")
+	}
+	if d := time.Since(status.Time); d < -time.Second || d > time.Second {
+		t.Errorf("reading stamped %s from now, want the poller's clock", d)
+	}
+
+	p.clock.inverterTime = true
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	status = sink.take()["inverter_status"][0]
+	if d := time.Until(status.Time); d < 110*time.Second || d > 130*time.Second {
+		t.Errorf("reading stamped %s from now, want the inverter's clock 2m ahead", d)
+	}
+}
+
+func TestMeterPollerInverterTime(t *testing.T) {
+	_, srv := froniustest.NewServer(froniustest.Config{Meter: true, ClockOffset: 2 * time.Minute})
+	t.Cleanup(srv.Close)
+	sink := &recordSink{}
+	p := &meterPoller{
+		meter:  deviceConfig{Name: "grid", URL: srv.URL, DeviceID: 0},
+		client: fronius.New(srv.URL, &http.Client{Timeout: time.Second}),
+		sink:   sink,
+		health: newHealth(),
+		clock:  deviceClock{label: "[grid] Meter", inverterTime: true},
+	}
+	if err := p.poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	meters := sink.take()["meter"]
+	if len(meters) != 1 {
+		t.Fatalf("got %d meter readings, want 1", len(meters))
+	}
+	if d := time.Until(meters[0].Time); d < 110*time.Second || d > 130*time.Second {
+		t.Errorf("reading stamped %s from now, want the datalogger's clock 2m ahead", d)
+	}
+	if !p.clock.drifting {
+		t.Error("two minutes of drift not flagged")
+	}
+}
+
+func TestDeviceClockZoneMismatch(t *testing.T) {
+	local := time.Local
+	time.Local = mustLoad(t, "Europe/Vienna")
+	t.Cleanup(func() { time.Local = local })
+
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	var c deviceClock
+	c.timestamp(now, now, "2026-07-01T14:00:00+02:00")
+	if c.zoneMismatch {
+		t.Error("datalogger in TZ's zone flagged")
+	}
+	c.timestamp(now, now, "2026-07-01T12:00:00+00:00")
+	if !c.zoneMismatch {
+		t.Error("datalogger at UTC+00:00 not flagged under Europe/Vienna")
+	}
+}
+
+func TestScheduleBackoff(t *testing.T) {
+	const interval = 20 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls []time.Time
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		schedule(ctx, "[test] Device", interval, func(context.Context) error {
+			calls = append(calls, time.Now())
+			switch {
+			case len(calls) <= 4:
+				return errors.New("unreachable")
+			case len(calls) == 6:
+				cancel()
+			}
+			return nil
+		})
+	}()
+	<-done
+
+	if len(calls) != 6 {
+		t.Fatalf("%d calls, want 6", len(calls))
+	}
+	// Four failures double the wait each time; success restores it.
+	for i, want := range []time.Duration{2 * interval, 4 * interval, 8 * interval, 16 * interval} {
+		if gap := calls[i+1].Sub(calls[i]); gap < want {
+			t.Errorf("wait after failure %d = %s, want at least %s", i+1, gap, want)
+		}
+	}
+	if gap := calls[5].Sub(calls[4]); gap >= 8*interval {
+		t.Errorf("wait after recovery = %s, want about %s", gap, interval)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricPrefix prefixes every exported metric name.
+const metricPrefix = "fronius_"
+
+// counterFields are fields that only ever increase, exported as counters with
+// a _total suffix. Other numeric fields are gauges; day_energy and year_energy
+// reset, so they are gauges too.
+var counterFields = map[string]map[string]bool{
+	"inverter":   {"total_energy": true},
+	"meter":      {"energy_import": true, "energy_export": true},
+	"power_flow": {"e_total": true},
+}
+
+// promSeries is the latest reading for one measurement and tag set.
+type promSeries struct {
+	measurement string
+	tags        map[string]string
+	values      map[string]float64
+	time        time.Time
+}
+
+// promSink keeps the latest value of every numeric field and serves them in
+// the Prometheus text exposition format on /metrics. It needs no other sink,
+// so it works without InfluxDB.
+type promSink struct {
+	health *health
+
+	mu     sync.Mutex
+	series map[string]*promSeries
+}
+
+func newPromSink(h *health) *promSink {
+	h.setSink("prometheus", nil, stateDown)
+	return &promSink{health: h, series: make(map[string]*promSeries)}
+}
+
+func (s *promSink) Name() string { return "prometheus" }
+
+func (s *promSink) Write(ctx context.Context, readings ...Reading) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range readings {
+		// Events are one-off transitions, not a current value.
+		if r.Measurement == "inverter_event" {
+			continue
+		}
+		values := make(map[string]float64)
+		for name, v := range r.Fields {
+			if f, ok := toFloat(v); ok {
+				values[name] = f
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		key := seriesKey(r.Measurement, r.Tags)
+		if prev, ok := s.series[key]; ok && r.Time.Before(prev.time) {
+			continue // a backfilled reading is older than what we have
+		}
+		s.series[key] = &promSeries{measurement: r.Measurement, tags: r.Tags, values: values, time: r.Time}
+	}
+	return nil
+}
+
+func (s *promSink) Close() error { return nil }
+
+func (s *promSink) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /metrics", s.serveMetrics)
+}
+
+// promSample is one line of exposition output.
+type promSample struct {
+	labels string
+	value  float64
+}
+
+// promFamily is every sample of one metric name.
+type promFamily struct {
+	help    string
+	kind    string // gauge or counter
+	samples []promSample
+}
+
+func (s *promSink) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	families := make(map[string]*promFamily)
+	add := func(name, help, kind, labels string, v float64) {
+		f, ok := families[name]
+		if !ok {
+			f = &promFamily{help: help, kind: kind}
+			families[name] = f
+		}
+		f.samples = append(f.samples, promSample{labels: labels, value: v})
+	}
+
+	s.mu.Lock()
+	for _, ser := range s.series {
+		labels := formatLabels(ser.tags)
+		for field, v := range ser.values {
+			name := metricPrefix + ser.measurement + "_" + field
+			if counterFields[ser.measurement][field] {
+				add(name+"_total", fmt.Sprintf("Latest %s field %s.", ser.measurement, field), "counter", labels, v)
+			} else {
+				add(name, fmt.Sprintf("Latest %s field %s.", ser.measurement, field), "gauge", labels, v)
+			}
+		}
+		add(metricPrefix+ser.measurement+"_last_reading_timestamp_seconds",
+			fmt.Sprintf("Unix time of the latest %s reading.", ser.measurement), "gauge",
+			labels, float64(ser.time.UnixMilli())/1000)
+		add(metricPrefix+ser.measurement+"_reading_age_seconds",
+			fmt.Sprintf("Seconds since the latest %s reading, as of this scrape.", ser.measurement), "gauge",
+			labels, now.Sub(ser.time).Seconds())
+	}
+	s.mu.Unlock()
+
+	for name, d := range s.health.deviceStates() {
+		kind, device, _ := strings.Cut(name, "/")
+		up := 0.0
+		if d.State == stateOK {
+			up = 1
+		}
+		add(metricPrefix+"device_up", "Whether the device answered its last poll.", "gauge",
+			formatLabels(map[string]string{"kind": kind, "device": device}), up)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(families)) {
+		f := families[name]
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind)
+		slices.SortFunc(f.samples, func(a, b promSample) int { return strings.Compare(a.labels, b.labels) })
+		for _, sm := range f.samples {
+			fmt.Fprintf(&b, "%s%s %s\n", name, sm.labels, strconv.FormatFloat(sm.value, 'g', -1, 64))
+		}
+	}
+	w.Write([]byte(b.String()))
+}
+
+// seriesKey identifies a measurement and tag set.
+func seriesKey(measurement string, tags map[string]string) string {
+	var b strings.Builder
+	b.WriteString(measurement)
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		b.WriteString("," + k + "=" + tags[k])
+	}
+	return b.String()
+}
+
+// formatLabels renders tags as a Prometheus label set, e.g. {device_id="roof"}.
+func formatLabels(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range slices.Sorted(maps.Keys(tags)) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k + `="` + escapeLabel(tags[k]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+// toFloat converts a numeric field value to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	default:
+		return 0, false
+	}
+}
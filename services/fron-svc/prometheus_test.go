@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// scrape returns the exposition lines of s's /metrics.
+func scrape(t *testing.T, s *promSink) []string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	s.serveMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the text exposition format", ct)
+	}
+	return strings.Split(strings.TrimSuffix(rec.Body.String(), "\n"), "\n")
+}
+
+// sample returns the value of the sample with the given name and labels.
+func sample(t *testing.T, lines []string, series string) float64 {
+	t.Helper()
+	for _, line := range lines {
+		if v, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				t.Fatalf("%s: %v", line, err)
+			}
+			return f
+		}
+	}
+	t.Fatalf("no sample %s in:\n%s", series, strings.Join(lines, "\n"))
+	return 0
+}
+
+func TestPromSink(t *testing.T) {
+	h := newHealth()
+	h.setDevice("inverter/roof", nil)
+	h.setDevice("meter/grid", errors.New("timeout"))
+	s := newPromSink(h)
+
+	now := time.Now()
+	err := s.Write(context.Background(),
+		Reading{
+			Measurement: "inverter",
+			Tags:        map[string]string{"device_id": "roof"},
+			Fields:      map[string]interface{}{"pac": 1200.0, "total_energy": 5000000.0, "day_energy": 8000.0, "state": "running"},
+			Time:        now.Add(-10 * time.Second),
+		},
+		Reading{
+			Measurement: "meter",
+			Tags:        map[string]string{"device_id": "grid", "location": "grid"},
+			Fields:      map[string]interface{}{"energy_import": 1500.0, "power": -700.0},
+			Time:        now.Add(-5 * time.Second),
+		},
+		Reading{
+			Measurement: "inverter_event",
+			Tags:        map[string]string{"device_id": "roof"},
+			Fields:      map[string]interface{}{"status_code": 7},
+			Time:        now,
+		},
+		// Backfilled, so older than what is already there.
+		Reading{
+			Measurement: "inverter",
+			Tags:        map[string]string{"device_id": "roof"},
+			Fields:      map[string]interface{}{"pac": 300.0},
+			Time:        now.Add(-time.Hour),
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := scrape(t, s)
+
+	for _, want := range []string{
+		"# TYPE fronius_inverter_total_energy_total counter",
+		`fronius_inverter_total_energy_total{device_id="roof"} 5e+06`,
+		"# TYPE fronius_meter_energy_import_total counter",
+		`fronius_meter_energy_import_total{device_id="grid",location="grid"} 1500`,
+		// Daily counters reset, so they are gauges.
+		"# TYPE fronius_inverter_day_energy gauge",
+		"# TYPE fronius_inverter_pac gauge",
+		`fronius_inverter_pac{device_id="roof"} 1200`,
+		`fronius_meter_power{device_id="grid",location="grid"} -700`,
+		"# HELP fronius_device_up Whether the device answered its last poll.",
+		`fronius_device_up{device="roof",kind="inverter"} 1`,
+		`fronius_device_up{device="grid",kind="meter"} 0`,
+	} {
+		if !slices.Contains(lines, want) {
+			t.Errorf("missing line %q", want)
+		}
+	}
+	for _, line := range lines {
+		if strings.Contains(line, "inverter_event") || strings.Contains(line, "_state") {
+			t.Errorf("unexpected line %q", line)
+		}
+	}
+
+	if got := sample(t, lines, `fronius_inverter_last_reading_timestamp_seconds{device_id="roof"}`); got != float64(now.Add(-10*time.Second).UnixMilli())/1000 {
+		t.Errorf("last_reading_timestamp_seconds = %v, want the reading's time", got)
+	}
+	if age := sample(t, lines, `fronius_meter_reading_age_seconds{device_id="grid",location="grid"}`); age < 5 || age > 10 {
+		t.Errorf("reading_age_seconds = %v, want about 5", age)
+	}
+}
+
+func TestFormatLabels(t *testing.T) {
+	if got := formatLabels(nil); got != "" {
+		t.Errorf("no tags: %q, want no label set", got)
+	}
+	got := formatLabels(map[string]string{"name": "garage \"west\"\\\n", "device_id": "roof"})
+	if want := `{device_id="roof",name="garage \"west\"\\\n"}`; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
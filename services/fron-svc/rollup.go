@@ -0,0 +1,112 @@
+package main
+
+import (
+	"maps"
+	"strings"
+	"time"
+)
+
+// peakFields are fields whose rollups also record the minimum, maximum and
+// time of the maximum, as <field>_min, <field>_max and <field>_peak_time.
+var peakFields = map[string]bool{"pac": true}
+
+// isEnergyField reports whether a field is an energy counter in Wh, such as
+// day_energy or the power flow e_total.
+func isEnergyField(field string) bool {
+	return strings.Contains(field, "energy") || strings.HasPrefix(field, "e_")
+}
+
+// sumFields are fields holding an amount for the interval since the previous
+// reading, whose rollup is their sum.
+var sumFields = map[string]bool{"earnings": true, "savings": true, "cost": true}
+
+// lastField reports whether a rollup keeps a field's last value rather than
+// its mean: energy counters and running daily totals such as day_earnings,
+// where the last value is the period's total, and status and error codes,
+// whose mean means nothing.
+func lastField(field string) bool {
+	return isEnergyField(field) || strings.HasPrefix(field, "day_") || strings.HasSuffix(field, "_code")
+}
+
+// rollup aggregates the readings of one series over one period: the mean of
+// instantaneous fields, the sum of sumFields, the last value of energy
+// counters, codes and strings, and the extremes of peakFields.
+type rollup struct {
+	start   time.Time
+	tags    map[string]string
+	samples int
+
+	sum   map[string]float64
+	count map[string]int
+	last  map[string]interface{}
+	min   map[string]float64
+	max   map[string]float64
+	peak  map[string]time.Time
+	lastT time.Time
+}
+
+func newRollup(start time.Time) *rollup {
+	return &rollup{
+		start: start,
+		tags:  make(map[string]string),
+		sum:   make(map[string]float64),
+		count: make(map[string]int),
+		last:  make(map[string]interface{}),
+		min:   make(map[string]float64),
+		max:   make(map[string]float64),
+		peak:  make(map[string]time.Time),
+	}
+}
+
+// add folds r into the rollup. Readings should arrive in time order; an
+// older reading does not replace a newer last value.
+func (a *rollup) add(r Reading) {
+	a.samples++
+	maps.Copy(a.tags, r.Tags)
+	newest := !r.Time.Before(a.lastT)
+	if newest {
+		a.lastT = r.Time
+	}
+
+	for field, v := range r.Fields {
+		f, numeric := toFloat(v)
+		if !numeric || lastField(field) {
+			if _, ok := a.last[field]; !ok || newest {
+				a.last[field] = v
+			}
+			continue
+		}
+		a.sum[field] += f
+		a.count[field]++
+		if !peakFields[field] {
+			continue
+		}
+		if lo, ok := a.min[field]; !ok || f < lo {
+			a.min[field] = f
+		}
+		if hi, ok := a.max[field]; !ok || f > hi {
+			a.max[field] = f
+			a.peak[field] = r.Time
+		}
+	}
+}
+
+// reading returns the aggregate as a reading stamped with the period start.
+func (a *rollup) reading(measurement string) Reading {
+	fields := make(map[string]interface{}, len(a.sum)+len(a.last)+1)
+	for field, sum := range a.sum {
+		if sumFields[field] {
+			fields[field] = sum
+		} else {
+			fields[field] = sum / float64(a.count[field])
+		}
+	}
+	maps.Copy(fields, a.last)
+	for field, hi := range a.max {
+		fields[field+"_min"] = a.min[field]
+		fields[field+"_max"] = hi
+		fields[field+"_peak_time"] = a.peak[field].UTC().Format(time.RFC3339)
+	}
+	fields["samples"] = a.samples
+	return Reading{Measurement: measurement, Tags: maps.Clone(a.tags), Fields: fields, Time: a.start}
+}
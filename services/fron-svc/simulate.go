@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius/froniustest"
+)
+
+// simulateCommand implements "fron-svc simulate": it serves a simulated
+// Fronius datalogger, so the service can be developed without an inverter.
+// Point INVERTER_URL at it.
+func simulateCommand(args []string) {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	addr := fs.String("addr", ":8080", "address to listen on")
+	inverters := fs.Int("inverters", 1, "number of inverters, at DeviceId 1..n")
+	peak := fs.Float64("peak", 5000, "AC output per inverter at solar noon, W")
+	sunrise := fs.Duration("sunrise", 6*time.Hour, "time of day production starts")
+	sunset := fs.Duration("sunset", 20*time.Hour, "time of day production ends")
+	trackers := fs.Int("trackers", 1, "MPPT trackers per inverter, 1-4")
+	threePhase := fs.Bool("three-phase", false, "simulate three-phase inverters")
+	meter := fs.Bool("meter", false, "simulate a grid meter at DeviceId 0")
+	storage := fs.Bool("storage", false, "simulate a battery at DeviceId 0")
+	load := fs.Float64("load", 500, "household consumption, W")
+	clockOffset := fs.Duration("clock-offset", 0, "how far the datalogger's clock runs ahead")
+	speed := fs.Float64("speed", 1, "how many times faster than real time the simulated day runs")
+	latency := fs.Duration("latency", 0, "delay before every response")
+	errorCode := fs.Int("error", 0, "report an inverter fault with this error code")
+	apiError := fs.Int("api-error", 0, "answer realtime requests with this Head.Status.Code")
+	hang := fs.Bool("hang", false, "never respond, to exercise timeouts")
+	fs.Parse(args)
+
+	if *speed <= 0 {
+		log.Fatalf("Invalid -speed: must be positive, got %v", *speed)
+	}
+	if *sunset <= *sunrise {
+		log.Fatalf("Invalid -sunset: %s is not after -sunrise %s", *sunset, *sunrise)
+	}
+
+	start := time.Now()
+	sim := froniustest.New(froniustest.Config{
+		Inverters:   *inverters,
+		PeakPower:   *peak,
+		Sunrise:     *sunrise,
+		Sunset:      *sunset,
+		Trackers:    *trackers,
+		ThreePhase:  *threePhase,
+		Meter:       *meter,
+		Storage:     *storage,
+		Load:        *load,
+		ClockOffset: *clockOffset,
+		Now: func() time.Time {
+			return start.Add(time.Duration(float64(time.Since(start)) * *speed))
+		},
+	})
+	sim.SetLatency(*latency)
+	sim.SetError(*errorCode)
+	sim.SetAPIError(*apiError)
+	sim.SetHang(*hang)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{Addr: *addr, Handler: sim}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("Simulating %d inverter(s) on %s", *inverters, *addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Simulator failed: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reading is one set of values read from a device at one time. Measurement,
+// tag and field names are those documented in the README's data model.
+type Reading struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// Sink is a destination for readings.
+type Sink interface {
+	// Name identifies the sink in SINKS, logs and /healthz.
+	Name() string
+	// Write stores readings. It returns an error only if they were lost; a
+	// sink that can hold readings back until its backend recovers does so.
+	Write(ctx context.Context, readings ...Reading) error
+	// Close releases the sink's resources once nothing more will be written.
+	Close() error
+}
+
+// sinkRunner is implemented by sinks with background work, such as replaying
+// a spool. run blocks until ctx is cancelled.
+type sinkRunner interface {
+	run(ctx context.Context)
+}
+
+// sinkRoutes is implemented by sinks that serve HTTP endpoints on the health
+// server, such as /metrics.
+type sinkRoutes interface {
+	register(mux *http.ServeMux)
+}
+
+// defaultSinks is used when SINKS is unset.
+const defaultSinks = "influxdb"
+
+// sinkNames parses SINKS, a comma-separated list of sink names.
+func sinkNames() ([]string, error) {
+	v := os.Getenv("SINKS")
+	if v == "" {
+		v = defaultSinks
+	}
+	var names []string
+	for name := range strings.SplitSeq(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if slices.Contains(names, name) {
+			return nil, fmt.Errorf("invalid SINKS: %q listed twice", name)
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("invalid SINKS: no sinks")
+	}
+	return names, nil
+}
+
+// openSinks creates the named sinks from their environment variables. With
+// spoolDir empty, sinks that would spool return write errors instead, which
+// suits one-off commands.
+func openSinks(names []string, spoolDir string, h *health) ([]Sink, error) {
+	var sinks []Sink
+	for _, name := range names {
+		var (
+			s   Sink
+			err error
+		)
+		switch name {
+		case "influxdb":
+			s, err = newInfluxSink(loadInfluxConfig(), spoolDir, h)
+		case "prometheus":
+			s = newPromSink(h)
+		case "csv":
+			s, err = newCSVSink(loadCSVConfig(), h)
+		case "mqtt":
+			s, err = newMQTTSink(loadMQTTConfig(), h)
+		default:
+			err = fmt.Errorf("unknown sink %q", name)
+		}
+		if err != nil {
+			closeSinks(sinks)
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+func closeSinks(sinks []Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			log.Printf("Closing %s sink failed: %v", s.Name(), err)
+		}
+	}
+}
+
+// multiSink fans readings out to every configured sink. A failing sink does
+// not stop the others, and its failure is logged rather than returned, so
+// sink problems never trigger device backoff.
+type multiSink struct {
+	sinks []Sink
+
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+func newMultiSink(sinks []Sink) *multiSink {
+	return &multiSink{sinks: sinks, failing: make(map[string]bool)}
+}
+
+func (m *multiSink) Name() string { return "multi" }
+
+func (m *multiSink) Write(ctx context.Context, readings ...Reading) error {
+	for _, s := range m.sinks {
+		err := s.Write(ctx, readings...)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		m.mu.Lock()
+		switch {
+		case err != nil && !m.failing[s.Name()]:
+			log.Printf("Writing to %s failed: %v", s.Name(), err)
+			m.failing[s.Name()] = true
+		case err == nil && m.failing[s.Name()]:
+			log.Printf("Writing to %s recovered", s.Name())
+			m.failing[s.Name()] = false
+		}
+		m.mu.Unlock()
+	}
+	return nil
+}
+
+func (m *multiSink) Close() error {
+	closeSinks(m.sinks)
+	return nil
+}
+
+// run starts the background work of every sink that has any.
+func (m *multiSink) run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, s := range m.sinks {
+		if r, ok := s.(sinkRunner); ok {
+			wg.Go(func() { r.run(ctx) })
+		}
+	}
+	wg.Wait()
+}
+
+// register adds the HTTP endpoints of every sink that serves any.
+func (m *multiSink) register(mux *http.ServeMux) {
+	for _, s := range m.sinks {
+		if r, ok := s.(sinkRoutes); ok {
+			r.register(mux)
+		}
+	}
+}
+
+// sinkList writes to every sink in turn and returns their errors joined. It
+// suits one-off commands, where a failure should stop the run.
+type sinkList []Sink
+
+func (l sinkList) Name() string { return "list" }
+
+func (l sinkList) Write(ctx context.Context, readings ...Reading) error {
+	var errs []error
+	for _, s := range l {
+		if err := s.Write(ctx, readings...); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (l sinkList) Close() error {
+	closeSinks(l)
+	return nil
+}
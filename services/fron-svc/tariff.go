@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// earningsDevice is the device_id tag of earnings readings, which cover the
+// whole site.
+const earningsDevice = "site"
+
+// touWindow is a time-of-use window: a price per kWh between two times of
+// day. A window whose end is before its start spans midnight.
+type touWindow struct {
+	from, to time.Duration
+	price    float64
+}
+
+// rate is a price per kWh: the first window containing the time of day, or
+// the flat price outside every window.
+type rate struct {
+	flat    float64
+	windows []touWindow
+}
+
+func (r rate) at(t time.Time) float64 {
+	// The wall clock, not the time since midnight, which is an hour off
+	// after a DST change.
+	h, m, sec := t.In(time.Local).Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	for _, w := range r.windows {
+		if w.from < w.to && tod >= w.from && tod < w.to ||
+			w.from > w.to && (tod >= w.from || tod < w.to) {
+			return w.price
+		}
+	}
+	return r.flat
+}
+
+func (r rate) String() string {
+	s := strconv.FormatFloat(r.flat, 'f', -1, 64) + "/kWh"
+	if len(r.windows) > 0 {
+		s += fmt.Sprintf(" (%d time-of-use windows)", len(r.windows))
+	}
+	return s
+}
+
+// tariffConfig holds the prices earnings are computed with, in the currency
+// of the user's choice.
+type tariffConfig struct {
+	FeedIn       rate    // paid per exported kWh
+	Import       rate    // charged per imported kWh
+	SupplyCharge float64 // charged per day with a grid meter
+}
+
+// loadTariff reads the tariff from TARIFF_FEED_IN, TARIFF_FEED_IN_WINDOWS,
+// TARIFF_IMPORT, TARIFF_IMPORT_WINDOWS and TARIFF_SUPPLY_CHARGE. It returns
+// nil if none is set.
+func loadTariff() (*tariffConfig, error) {
+	var (
+		cfg tariffConfig
+		set bool
+		err error
+	)
+	for _, p := range []struct {
+		key string
+		dst *float64
+	}{
+		{"TARIFF_FEED_IN", &cfg.FeedIn.flat},
+		{"TARIFF_IMPORT", &cfg.Import.flat},
+		{"TARIFF_SUPPLY_CHARGE", &cfg.SupplyCharge},
+	} {
+		v := os.Getenv(p.key)
+		if v == "" {
+			continue
+		}
+		set = true
+		if *p.dst, err = strconv.ParseFloat(v, 64); err != nil || *p.dst < 0 {
+			return nil, fmt.Errorf("invalid %s: must be a non-negative number, got %q", p.key, v)
+		}
+	}
+	for _, p := range []struct {
+		key string
+		dst *[]touWindow
+	}{
+		{"TARIFF_FEED_IN_WINDOWS", &cfg.FeedIn.windows},
+		{"TARIFF_IMPORT_WINDOWS", &cfg.Import.windows},
+	} {
+		v := os.Getenv(p.key)
+		if v == "" {
+			continue
+		}
+		set = true
+		if *p.dst, err = parseWindows(v); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", p.key, err)
+		}
+	}
+	if !set {
+		return nil, nil
+	}
+	return &cfg, nil
+}
+
+// parseWindows parses a semicolon-separated list of from-to,price entries,
+// e.g.
+//
+//	16:00-21:00,0.45;21:00-07:00,0.18
+func parseWindows(v string) ([]touWindow, error) {
+	entries, err := splitList(v, "from-to,price")
+	if err != nil {
+		return nil, err
+	}
+	var windows []touWindow
+	for i, fields := range entries {
+		from, to, ok := strings.Cut(fields[0], "-")
+		if !ok {
+			return nil, fmt.Errorf("entry %d: want HH:MM-HH:MM, got %q", i+1, fields[0])
+		}
+		var w touWindow
+		if w.from, err = parseTimeOfDay(from); err == nil {
+			w.to, err = parseTimeOfDay(to)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i+1, err)
+		}
+		if w.from == w.to {
+			return nil, fmt.Errorf("entry %d: window %q is empty", i+1, fields[0])
+		}
+		if w.price, err = strconv.ParseFloat(fields[1], 64); err != nil || w.price < 0 {
+			return nil, fmt.Errorf("entry %d: price must be a non-negative number, got %q", i+1, fields[1])
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// parseTimeOfDay parses HH:MM as the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("want HH:MM, got %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// meterWait is how long production is held back from pricing while waiting
+// for every configured meter to be read, so it is not priced as all feed-in
+// only because the grid meter has not answered yet.
+const meterWait = time.Minute
+
+// earningsSink prices the energy in readings before passing them on, adding
+// an earnings reading whenever an energy counter moves:
+//
+//   - Without a grid meter, all production is taken to be exported: each
+//     day_energy increment earns the feed-in rate.
+//   - With one, energy_export earns the feed-in rate, energy_import costs the
+//     import rate, and production that was not exported saves the import
+//     rate.
+//
+// Whether there is a grid meter is only known once the configured meters
+// have been read, so until then, for at most meterWait, inverter readings
+// are not priced and do not set a baseline.
+//
+// Increments are priced at the rates in force when they are read, and the
+// earnings reading is stamped with the latest reading priced. The first
+// reading of each counter only sets a baseline, and readings older than the
+// latest are ignored. Counters and today's totals are kept in the
+// checkpoints file, so after a restart energy produced while the service was
+// down is priced at the rates in force when it is next read. Backfilled
+// readings bypass the sink altogether.
+type earningsSink struct {
+	next        Sink
+	tariff      tariffConfig
+	checkpoints *checkpoints
+
+	mu       sync.Mutex
+	pending  map[string]bool      // configured meters not yet read, by device_id
+	heldFrom time.Time            // time of the first inverter reading held back
+	metered  bool                 // a grid meter has been read
+	counters map[string]float64   // last value, by series key and field
+	times    map[string]time.Time // time of the last value, by series key and field
+	day      time.Time            // start of the day totalled
+	total    map[string]float64   // earnings, savings and cost so far today
+}
+
+// earningsState is the part of an earningsSink kept in the checkpoints file.
+type earningsState struct {
+	Metered  bool                 `json:"metered"`
+	Counters map[string]float64   `json:"counters"`
+	Times    map[string]time.Time `json:"times"`
+	Day      time.Time            `json:"day"`
+	Total    map[string]float64   `json:"total"`
+}
+
+// newEarningsSink returns a sink that prices readings on their way to next,
+// carrying on from the state saved in cps. meters are the device_id tags of
+// the configured meters.
+func newEarningsSink(tariff tariffConfig, meters []string, cps *checkpoints, next Sink) *earningsSink {
+	s := &earningsSink{
+		next:        next,
+		tariff:      tariff,
+		checkpoints: cps,
+		pending:     make(map[string]bool),
+		counters:    make(map[string]float64),
+		times:       make(map[string]time.Time),
+		total:       make(map[string]float64),
+	}
+	for _, m := range meters {
+		s.pending[m] = true
+	}
+	if st := cps.getEarnings(); st != nil {
+		s.metered = st.Metered
+		maps.Copy(s.counters, st.Counters)
+		for k, t := range st.Times {
+			s.times[k] = t.Local()
+		}
+		s.day = st.Day.Local()
+		maps.Copy(s.total, st.Total)
+	}
+	if s.metered {
+		// A grid meter was read before the restart.
+		clear(s.pending)
+	}
+	return s
+}
+
+func (s *earningsSink) Name() string { return "earnings" }
+
+func (s *earningsSink) Write(ctx context.Context, readings ...Reading) error {
+	if r, ok := s.price(readings); ok {
+		readings = append(readings, r)
+	}
+	return s.next.Write(ctx, readings...)
+}
+
+func (s *earningsSink) Close() error { return s.next.Close() }
+
+// price returns the earnings reading for the energy counted in readings, or
+// false if none was.
+func (s *earningsSink) price(readings []Reading) (Reading, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inc := make(map[string]float64)
+	var (
+		priced  bool
+		counted bool      // a counter was read, so the state changed
+		at      time.Time // time of the latest reading priced
+	)
+	count := func(r Reading) {
+		priced = true
+		if r.Time.After(at) {
+			at = r.Time
+		}
+	}
+	for _, r := range readings {
+		switch {
+		case r.Measurement == "inverter":
+			if s.hold(r.Time) {
+				continue
+			}
+			counted = true
+			kWh, ok := s.increment(r, "day_energy", true)
+			if !ok {
+				continue
+			}
+			if s.metered {
+				inc["savings"] += kWh * s.tariff.Import.at(r.Time)
+			} else {
+				inc["earnings"] += kWh * s.tariff.FeedIn.at(r.Time)
+			}
+			count(r)
+		case r.Measurement == "meter":
+			delete(s.pending, r.Tags["device_id"])
+			if r.Tags["location"] != "grid" {
+				continue
+			}
+			s.metered = true
+			counted = true
+			if kWh, ok := s.increment(r, "energy_export", false); ok {
+				inc["earnings"] += kWh * s.tariff.FeedIn.at(r.Time)
+				inc["savings"] -= kWh * s.tariff.Import.at(r.Time)
+				count(r)
+			}
+			if kWh, ok := s.increment(r, "energy_import", false); ok {
+				inc["cost"] += kWh * s.tariff.Import.at(r.Time)
+				count(r)
+			}
+		}
+	}
+	if counted {
+		defer s.save()
+	}
+	if !priced {
+		return Reading{}, false
+	}
+	if day := startOfDay(at); day.After(s.day) {
+		s.day = day
+		clear(s.total)
+	}
+
+	fields := map[string]interface{}{
+		"earnings":     inc["earnings"],
+		"savings":      inc["savings"],
+		"day_earnings": s.total["earnings"] + inc["earnings"],
+		"day_savings":  s.total["savings"] + inc["savings"],
+	}
+	if s.metered {
+		fields["cost"] = inc["cost"]
+		fields["day_cost"] = s.total["cost"] + inc["cost"] + s.tariff.SupplyCharge
+	}
+	for k, v := range inc {
+		s.total[k] += v
+	}
+	return Reading{
+		Measurement: "earnings",
+		Tags:        map[string]string{"device_id": earningsDevice},
+		Fields:      fields,
+		Time:        at,
+	}, true
+}
+
+// hold reports whether an inverter reading taken at t is held back while
+// waiting for the configured meters to be read.
+func (s *earningsSink) hold(t time.Time) bool {
+	if len(s.pending) == 0 {
+		return false
+	}
+	if s.heldFrom.IsZero() {
+		s.heldFrom = t
+	}
+	if t.Sub(s.heldFrom) < meterWait {
+		return true
+	}
+	log.Printf("Meter(s) %s not read within %s, pricing production as feed-in", strings.Join(slices.Sorted(maps.Keys(s.pending)), ", "), meterWait)
+	clear(s.pending)
+	return false
+}
+
+// save records the state in the checkpoints file.
+func (s *earningsSink) save() {
+	s.checkpoints.setEarnings(&earningsState{
+		Metered:  s.metered,
+		Counters: maps.Clone(s.counters),
+		Times:    maps.Clone(s.times),
+		Day:      s.day,
+		Total:    maps.Clone(s.total),
+	})
+}
+
+// increment returns how many kWh a Wh counter field of r has risen since the
+// last reading of its series. A daily counter restarts at zero on a new day,
+// so its first value that day is all increment; any other counter that falls
+// has been replaced and only sets a new baseline.
+func (s *earningsSink) increment(r Reading, field string, daily bool) (float64, bool) {
+	v, ok := toFloat(r.Fields[field])
+	if !ok {
+		return 0, false
+	}
+	key := seriesKey(r.Measurement, r.Tags) + "/" + field
+	last, seen := s.counters[key]
+	lastT := s.times[key]
+	if seen && !r.Time.After(lastT) {
+		return 0, false
+	}
+	s.counters[key] = v
+	s.times[key] = r.Time
+	switch {
+	case !seen:
+		return 0, false
+	case daily && startOfDay(r.Time).After(startOfDay(lastT)):
+		return v / 1000, v > 0
+	case v < last:
+		return 0, false
+	default:
+		return (v - last) / 1000, v > last
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseWindows(t *testing.T) {
+	got, err := parseWindows("16:00-21:00,0.45; 21:00-07:00,0.18")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []touWindow{
+		{from: 16 * time.Hour, to: 21 * time.Hour, price: 0.45},
+		{from: 21 * time.Hour, to: 7 * time.Hour, price: 0.18},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d windows, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("window %d = %+v, want %+v", i+1, got[i], want[i])
+		}
+	}
+
+	for _, v := range []string{
+		"16:00,0.45",
+		"16:00-21:00",
+		"16:00-25:00,0.45",
+		"4pm-9pm,0.45",
+		"16:00-16:00,0.45",
+		"16:00-21:00,cheap",
+		"16:00-21:00,-0.1",
+	} {
+		if _, err := parseWindows(v); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+}
+
+func TestRateAt(t *testing.T) {
+	r := rate{
+		flat: 0.30,
+		windows: []touWindow{
+			{from: 16 * time.Hour, to: 21 * time.Hour, price: 0.45},
+			{from: 21 * time.Hour, to: 7 * time.Hour, price: 0.18},
+		},
+	}
+	at := func(hour, min int) time.Time { return time.Date(2026, 6, 15, hour, min, 0, 0, time.Local) }
+	for _, tc := range []struct {
+		t    time.Time
+		want float64
+	}{
+		{at(12, 0), 0.30},
+		{at(16, 0), 0.45},
+		{at(20, 59), 0.45},
+		{at(21, 0), 0.18},
+		{at(23, 30), 0.18},
+		{at(0, 0), 0.18},
+		{at(3, 0), 0.18},
+		{at(6, 59), 0.18},
+		{at(7, 0), 0.30},
+		{at(3, 0).UTC(), 0.18}, // windows are in local time
+	} {
+		if got := r.at(tc.t); got != tc.want {
+			t.Errorf("at(%s) = %v, want %v", tc.t.Format("15:04 MST"), got, tc.want)
+		}
+	}
+}
+
+func TestRateAtDSTChange(t *testing.T) {
+	local := time.Local
+	time.Local = mustLoad(t, "Europe/London")
+	t.Cleanup(func() { time.Local = local })
+
+	r := rate{flat: 0.30, windows: []touWindow{{from: 21 * time.Hour, to: 7 * time.Hour, price: 0.18}}}
+	for _, tc := range []struct {
+		t    time.Time
+		want float64
+	}{
+		// Clocks go forward at 01:00 on 29 March, so 07:00 is six hours
+		// after midnight.
+		{time.Date(2026, 3, 29, 6, 59, 0, 0, time.Local), 0.18},
+		{time.Date(2026, 3, 29, 7, 0, 0, 0, time.Local), 0.30},
+		// Clocks go back at 02:00 on 25 October, so 06:30 is seven and a
+		// half hours after midnight.
+		{time.Date(2026, 10, 25, 6, 30, 0, 0, time.Local), 0.18},
+		{time.Date(2026, 10, 25, 7, 0, 0, 0, time.Local), 0.30},
+	} {
+		if got := r.at(tc.t); got != tc.want {
+			t.Errorf("at(%s) = %v, want %v", tc.t.Format("Jan 2 15:04 MST"), got, tc.want)
+		}
+	}
+}
+
+func inverterReading(t time.Time, dayEnergy float64) Reading {
+	return Reading{
+		Measurement: "inverter",
+		Tags:        map[string]string{"device_id": "roof"},
+		Fields:      map[string]interface{}{"day_energy": dayEnergy},
+		Time:        t,
+	}
+}
+
+func gridReading(t time.Time, export, imp float64) Reading {
+	return Reading{
+		Measurement: "meter",
+		Tags:        map[string]string{"device_id": "grid", "location": "grid"},
+		Fields:      map[string]interface{}{"energy_export": export, "energy_import": imp},
+		Time:        t,
+	}
+}
+
+// earnings writes readings through s and returns the earnings reading added,
+// if any.
+func earnings(t *testing.T, s *earningsSink, next *recordSink, readings ...Reading) (Reading, bool) {
+	t.Helper()
+	if err := s.Write(context.Background(), readings...); err != nil {
+		t.Fatal(err)
+	}
+	got := next.take()["earnings"]
+	if len(got) > 1 {
+		t.Fatalf("got %d earnings readings, want at most 1", len(got))
+	}
+	if len(got) == 0 {
+		return Reading{}, false
+	}
+	return got[0], true
+}
+
+// newTestEarnings returns an earnings sink with checkpoints in a temporary
+// file, writing to a recordSink.
+func newTestEarnings(t *testing.T, tariff tariffConfig, meters ...string) (*earningsSink, *recordSink, *checkpoints) {
+	t.Helper()
+	cps, err := loadCheckpoints(filepath.Join(t.TempDir(), "checkpoints.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := &recordSink{}
+	return newEarningsSink(tariff, meters, cps, next), next, cps
+}
+
+func field(t *testing.T, r Reading, name string) float64 {
+	t.Helper()
+	v, ok := toFloat(r.Fields[name])
+	if !ok {
+		t.Fatalf("field %s missing from %+v", name, r.Fields)
+	}
+	return v
+}
+
+func near(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+func TestEarningsWithoutMeter(t *testing.T) {
+	s, next, _ := newTestEarnings(t, tariffConfig{FeedIn: rate{flat: 0.10}})
+	day := time.Date(2026, 6, 15, 12, 0, 0, 0, time.Local)
+
+	// The first reading only sets a baseline.
+	if r, ok := earnings(t, s, next, inverterReading(day, 5000)); ok {
+		t.Errorf("first reading priced: %+v", r.Fields)
+	}
+
+	r, ok := earnings(t, s, next, inverterReading(day.Add(time.Minute), 7000))
+	if !ok {
+		t.Fatal("2 kWh produced but not priced")
+	}
+	if got := field(t, r, "earnings"); !near(got, 0.20) {
+		t.Errorf("earnings = %v, want 0.20", got)
+	}
+	if !r.Time.Equal(day.Add(time.Minute)) {
+		t.Errorf("stamped %s, want the reading's time %s", r.Time, day.Add(time.Minute))
+	}
+	if _, ok := r.Fields["cost"]; ok {
+		t.Error("cost written without a grid meter")
+	}
+
+	// Readings no newer than the latest are ignored.
+	if r, ok := earnings(t, s, next, inverterReading(day.Add(-time.Minute), 9000)); ok {
+		t.Errorf("older reading priced: %+v", r.Fields)
+	}
+	if r, ok := earnings(t, s, next, inverterReading(day.Add(time.Minute), 9000)); ok {
+		t.Errorf("repeated reading priced: %+v", r.Fields)
+	}
+
+	r, _ = earnings(t, s, next, inverterReading(day.Add(2*time.Minute), 8000))
+	if got := field(t, r, "day_earnings"); !near(got, 0.30) {
+		t.Errorf("day_earnings = %v, want 0.30", got)
+	}
+}
+
+func TestEarningsDayRollover(t *testing.T) {
+	s, next, _ := newTestEarnings(t, tariffConfig{FeedIn: rate{flat: 0.10}})
+	evening := time.Date(2026, 6, 15, 21, 0, 0, 0, time.Local)
+	morning := time.Date(2026, 6, 16, 6, 0, 0, 0, time.Local)
+
+	earnings(t, s, next, inverterReading(evening, 30000))
+	earnings(t, s, next, inverterReading(evening.Add(time.Minute), 31000))
+
+	// day_energy restarts at midnight: its first value is all produced today.
+	r, ok := earnings(t, s, next, inverterReading(morning, 500))
+	if !ok {
+		t.Fatal("first reading of the day not priced")
+	}
+	if got := field(t, r, "earnings"); !near(got, 0.05) {
+		t.Errorf("earnings = %v, want 0.05", got)
+	}
+	if got := field(t, r, "day_earnings"); !near(got, 0.05) {
+		t.Errorf("day_earnings = %v, want 0.05: yesterday's total carried over", got)
+	}
+	if !r.Time.Equal(morning) {
+		t.Errorf("stamped %s, want %s", r.Time, morning)
+	}
+}
+
+func TestEarningsWithMeter(t *testing.T) {
+	s, next, _ := newTestEarnings(t, tariffConfig{
+		FeedIn:       rate{flat: 0.10},
+		Import:       rate{flat: 0.30},
+		SupplyCharge: 1,
+	})
+	day := time.Date(2026, 6, 15, 12, 0, 0, 0, time.Local)
+
+	earnings(t, s, next, inverterReading(day, 5000), gridReading(day, 100000, 200000))
+
+	// 3 kWh produced, 2 kWh of it exported, 1 kWh imported.
+	t1 := day.Add(time.Hour)
+	r, ok := earnings(t, s, next, gridReading(t1, 102000, 201000), inverterReading(t1.Add(-time.Second), 8000))
+	if !ok {
+		t.Fatal("energy not priced")
+	}
+	for name, want := range map[string]float64{
+		"earnings": 0.20,
+		"savings":  0.30,
+		"cost":     0.30,
+		"day_cost": 1.30,
+	} {
+		if got := field(t, r, name); !near(got, want) {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if !r.Time.Equal(t1) {
+		t.Errorf("stamped %s, want the latest reading's time %s", r.Time, t1)
+	}
+
+	// A lifetime counter that falls has been replaced: it is a new baseline,
+	// not a negative increment.
+	t2 := t1.Add(time.Minute)
+	if r, ok := earnings(t, s, next, gridReading(t2, 50, 10)); ok {
+		t.Errorf("counter reset priced: %+v", r.Fields)
+	}
+	r, ok = earnings(t, s, next, gridReading(t2.Add(time.Minute), 1050, 10))
+	if !ok {
+		t.Fatal("export after the reset not priced")
+	}
+	if got := field(t, r, "earnings"); !near(got, 0.10) {
+		t.Errorf("earnings after the reset = %v, want 0.10", got)
+	}
+	if got := field(t, r, "day_earnings"); !near(got, 0.30) {
+		t.Errorf("day_earnings = %v, want 0.30", got)
+	}
+}
+
+func TestEarningsHeldForMeter(t *testing.T) {
+	s, next, _ := newTestEarnings(t, tariffConfig{
+		FeedIn: rate{flat: 0.10},
+		Import: rate{flat: 0.30},
+	}, "grid")
+	day := time.Date(2026, 6, 15, 12, 0, 0, 0, time.Local)
+
+	// Production before the grid meter answers is neither priced as feed-in
+	// nor a baseline.
+	earnings(t, s, next, inverterReading(day, 5000))
+	if r, ok := earnings(t, s, next, inverterReading(day.Add(5*time.Second), 6000)); ok {
+		t.Errorf("production priced before the meter was read: %+v", r.Fields)
+	}
+
+	earnings(t, s, next, gridReading(day.Add(10*time.Second), 100000, 200000))
+	earnings(t, s, next, inverterReading(day.Add(15*time.Second), 7000))
+	r, ok := earnings(t, s, next, inverterReading(day.Add(20*time.Second), 8000))
+	if !ok {
+		t.Fatal("production not priced once the meter was read")
+	}
+	if got := field(t, r, "savings"); !near(got, 0.30) {
+		t.Errorf("savings = %v, want 0.30", got)
+	}
+	if got := field(t, r, "earnings"); got != 0 {
+		t.Errorf("earnings = %v, want 0", got)
+	}
+
+	// A meter that never answers holds pricing back for meterWait at most.
+	s, next, _ = newTestEarnings(t, tariffConfig{FeedIn: rate{flat: 0.10}}, "grid")
+	earnings(t, s, next, inverterReading(day, 5000))
+	earnings(t, s, next, inverterReading(day.Add(meterWait), 6000))
+	r, ok = earnings(t, s, next, inverterReading(day.Add(meterWait+time.Minute), 7000))
+	if !ok {
+		t.Fatal("production still held after meterWait")
+	}
+	if got := field(t, r, "earnings"); !near(got, 0.10) {
+		t.Errorf("earnings = %v, want 0.10", got)
+	}
+}
+
+func TestEarningsSurviveRestart(t *testing.T) {
+	tariff := tariffConfig{
+		FeedIn: rate{flat: 0.10},
+		Import: rate{flat: 0.30},
+	}
+	s, next, cps := newTestEarnings(t, tariff, "grid")
+	day := time.Date(2026, 6, 15, 12, 0, 0, 0, time.Local)
+
+	earnings(t, s, next, gridReading(day, 100000, 200000), inverterReading(day, 5000))
+	earnings(t, s, next, gridReading(day.Add(time.Minute), 101000, 200000), inverterReading(day.Add(time.Minute), 7000))
+	cps.save()
+
+	cps, err := loadCheckpoints(cps.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newEarningsSink(tariff, []string{"grid"}, cps, next)
+
+	// The meter was read before the restart, so nothing is held back, and
+	// the counters carry on from their saved values.
+	t1 := day.Add(time.Hour)
+	r, ok := earnings(t, s, next, inverterReading(t1, 9000))
+	if !ok {
+		t.Fatal("first reading after the restart not priced")
+	}
+	if got := field(t, r, "savings"); !near(got, 0.60) {
+		t.Errorf("savings = %v, want 0.60", got)
+	}
+	if got := field(t, r, "day_earnings"); !near(got, 0.10) {
+		t.Errorf("day_earnings = %v, want 0.10: today's total was lost", got)
+	}
+	if got := field(t, r, "day_savings"); !near(got, 0.90) {
+		t.Errorf("day_savings = %v, want 0.90", got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.local/services/fron-svc/internal/fronius"
+)
+
+// discoveryTimeout bounds discovery of each datalogger at startup.
+const discoveryTimeout = 10 * time.Second
+
+// discoveredDevice is one device reported by GetActiveDeviceInfo.
+type discoveredDevice struct {
+	DeviceID   int    `json:"device_id"`
+	DeviceType int    `json:"device_type"`
+	Serial     string `json:"serial,omitempty"`
+}
+
+// dataloggerTopology is what discovery found on one datalogger. Error is set
+// when discovery failed, in which case the configured devices are used as-is.
+type dataloggerTopology struct {
+	URL                string             `json:"url"`
+	APIVersion         int                `json:"api_version,omitempty"`
+	CompatibilityRange string             `json:"compatibility_range,omitempty"`
+	Inverters          []discoveredDevice `json:"inverters"`
+	Meters             []discoveredDevice `json:"meters"`
+	Storage            []discoveredDevice `json:"storage"`
+	SensorCards        []discoveredDevice `json:"sensor_cards"`
+	StringControls     []discoveredDevice `json:"string_controls"`
+	Ohmpilots          []discoveredDevice `json:"ohmpilots"`
+	Error              string             `json:"error,omitempty"`
+}
+
+// topology is served on GET /api/topology: what was discovered, and which
+// pollers were started as a result.
+type topology struct {
+	Dataloggers []dataloggerTopology `json:"dataloggers"`
+	Pollers     struct {
+		Inverters []string `json:"inverters"`
+		Meters    []string `json:"meters"`
+		Storage   []string `json:"storage"`
+		PowerFlow []string `json:"power_flow"`
+	} `json:"pollers"`
+}
+
+// discoverAll discovers every datalogger concurrently, returning results in
+// the order of urls.
+func discoverAll(ctx context.Context, urls []string, clients map[string]*fronius.Client) []dataloggerTopology {
+	results := make([]dataloggerTopology, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Go(func() { results[i] = discover(ctx, url, clients[url]) })
+	}
+	wg.Wait()
+	return results
+}
+
+// discover queries one datalogger's API version and active devices.
+func discover(ctx context.Context, url string, client *fronius.Client) dataloggerTopology {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+
+	t := dataloggerTopology{URL: url}
+
+	version, err := client.FetchAPIVersion(ctx)
+	if err != nil {
+		t.Error = fmt.Sprintf("GetAPIVersion: %v", err)
+		return t
+	}
+	t.APIVersion = version.APIVersion
+	t.CompatibilityRange = version.CompatibilityRange
+
+	info, err := client.FetchActiveDevices(ctx)
+	if err != nil {
+		t.Error = fmt.Sprintf("GetActiveDeviceInfo: %v", err)
+		return t
+	}
+	d := info.Body.Data
+	t.Inverters = devices(d.Inverter)
+	t.Meters = devices(d.Meter)
+	t.Storage = devices(d.Storage)
+	t.SensorCards = devices(d.SensorCard)
+	t.StringControls = devices(d.StringControl)
+	t.Ohmpilots = devices(d.Ohmpilot)
+	return t
+}
+
+func devices(active fronius.ActiveDevices) []discoveredDevice {
+	out := []discoveredDevice{}
+	for _, id := range active.IDs() {
+		dev := active[strconv.Itoa(id)]
+		out = append(out, discoveredDevice{DeviceID: id, DeviceType: dev.DT, Serial: dev.Serial})
+	}
+	return out
+}
+
+// logTopology logs what discovery found on each datalogger.
+func logTopology(dataloggers []dataloggerTopology) {
+	log.Println("Discovered topology:")
+	for _, t := range dataloggers {
+		if t.Error != "" {
+			log.Printf("  %s: discovery failed, using configured devices (%s)", t.URL, t.Error)
+			continue
+		}
+		log.Printf("  %s: Solar API v%d (%s)", t.URL, t.APIVersion, t.CompatibilityRange)
+		for _, class := range []struct {
+			name    string
+			devices []discoveredDevice
+		}{
+			{"inverter", t.Inverters},
+			{"meter", t.Meters},
+			{"storage", t.Storage},
+			{"sensor card", t.SensorCards},
+			{"string control", t.StringControls},
+			{"ohmpilot", t.Ohmpilots},
+		} {
+			for _, d := range class.devices {
+				log.Printf("    %-14s DeviceId %d (type %d, serial %s)", class.name, d.DeviceID, d.DeviceType, d.Serial)
+			}
+		}
+	}
+	log.Println()
+}
+
+// discoveredName names a device found by discovery. With several dataloggers
+// the datalogger host is appended so names stay unique.
+func discoveredName(kind string, deviceID int, url string, multi bool) string {
+	name := kind + "-" + strconv.Itoa(deviceID)
+	if multi {
+		name += "@" + dataloggerTag(url)
+	}
+	return name
+}
+
+// discoveredDevices returns a deviceConfig for every device selected by class
+// on each successfully discovered datalogger.
+func discoveredDevices(dataloggers []dataloggerTopology, kind string, class func(dataloggerTopology) []discoveredDevice) []deviceConfig {
+	var out []deviceConfig
+	for _, t := range dataloggers {
+		for _, d := range class(t) {
+			out = append(out, deviceConfig{
+				Name:     discoveredName(kind, d.DeviceID, t.URL, len(dataloggers) > 1),
+				URL:      t.URL,
+				DeviceID: d.DeviceID,
+			})
+		}
+	}
+	return out
+}
+
+// serveTopology serves the topology as JSON.
+func serveTopology(t *topology) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(t)
+	}
+}
+
+// dataloggerURLs returns the distinct datalogger URLs of all configured devices.
+func dataloggerURLs(inverters []inverterConfig, meters, storage []deviceConfig) []string {
+	seen := make(map[string]bool)
+	var urls []string
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	for _, inv := range inverters {
+		add(inv.URL)
+	}
+	for _, d := range append(meters, storage...) {
+		add(d.URL)
+	}
+	return urls
+}
+
+// discoveredInverters replaces the single INVERTER_URL inverter with every
+// inverter found on its datalogger. The one at the configured DeviceId keeps
+// the configured name, so its series carry on; the others are named
+// fronius-<id>. Each is rated at its entry in capacities, or the configured
+// capacity without one.
+func discoveredInverters(t dataloggerTopology, legacy inverterConfig, capacities map[int]float64) []inverterConfig {
+	var out []inverterConfig
+	for _, d := range t.Inverters {
+		inv := legacy
+		inv.DeviceID = d.DeviceID
+		if d.DeviceID != legacy.DeviceID {
+			inv.Name = defaultDeviceTag + "-" + strconv.Itoa(d.DeviceID)
+		}
+		if c, ok := capacities[d.DeviceID]; ok {
+			inv.CapacityW = c
+		} else if len(t.Inverters) > 1 {
+			log.Printf("No INVERTER_CAPACITIES_W entry for inverter %d, assuming INVERTER_CAPACITY_W", d.DeviceID)
+		}
+		out = append(out, inv)
+	}
+	return out
+}
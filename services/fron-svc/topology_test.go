@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestDiscoveredInverters(t *testing.T) {
+	legacy := inverterConfig{Name: defaultDeviceTag, URL: "http://inverter", DeviceID: 1, CapacityW: 8200}
+	topo := dataloggerTopology{
+		URL:       "http://inverter",
+		Inverters: []discoveredDevice{{DeviceID: 1}, {DeviceID: 2}, {DeviceID: 3}},
+	}
+
+	got := discoveredInverters(topo, legacy, map[int]float64{2: 5000})
+	want := []inverterConfig{
+		{Name: "fronius", URL: "http://inverter", DeviceID: 1, CapacityW: 8200},
+		{Name: "fronius-2", URL: "http://inverter", DeviceID: 2, CapacityW: 5000},
+		{Name: "fronius-3", URL: "http://inverter", DeviceID: 3, CapacityW: 8200},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d inverters, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("inverter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestLoadInverterCapacities(t *testing.T) {
+	t.Setenv("INVERTER_CAPACITIES_W", "2,5000; 3,3000")
+	got, err := loadInverterCapacities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[2] != 5000 || got[3] != 3000 {
+		t.Errorf("got %v", got)
+	}
+
+	for _, v := range []string{"2", "x,5000", "2,0", "2,5000;2,3000"} {
+		t.Setenv("INVERTER_CAPACITIES_W", v)
+		if _, err := loadInverterCapacities(); err == nil {
+			t.Errorf("%q: want an error", v)
+		}
+	}
+}